@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Serajian/go-configmgr/configmgr"
+	"gopkg.in/yaml.v3"
+)
+
+// keyring builds the keyring from the primary secret (read from keyFile, the
+// output of keyCmd or the variable keyEnv, in that order; with id keyID) and
+// the older secrets in the comma-separated variables oldKeyEnv.
+func keyring(keyEnv, keyFile, keyCmd, keyID, oldKeyEnv string) (*configmgr.Keyring, error) {
+	var primary configmgr.KeyProvider
+	switch {
+	case keyFile != "":
+		primary = configmgr.FileKey(keyFile)
+	case keyCmd != "":
+		args := strings.Fields(keyCmd)
+		if len(args) == 0 {
+			return nil, errors.New("-key-cmd is empty")
+		}
+		primary = configmgr.CommandKey(args[0], args[1:]...)
+	default:
+		primary = configmgr.EnvKey(keyEnv)
+	}
+	providers := []configmgr.KeyProvider{configmgr.NamedKey(keyID, primary)}
+	for _, name := range splitList(oldKeyEnv) {
+		providers = append(providers, configmgr.EnvKey(name))
+	}
+	return configmgr.CombineKeys(providers...).Keys(context.Background())
+}
+
+// kdfOptions maps the -kdf flag to encryption options using the default parameters.
+func kdfOptions(kdf string) ([]configmgr.EncryptOption, error) {
+	switch kdf {
+	case "argon2id":
+		return nil, nil
+	case "scrypt":
+		return []configmgr.EncryptOption{configmgr.WithScrypt(1<<15, 8, 1)}, nil
+	default:
+		return nil, fmt.Errorf("unknown key derivation function: %s", kdf)
+	}
+}
+
+// encrypt writes in encrypted to out (default in + ".enc").
+func encrypt(in, out, secret string, opts ...configmgr.EncryptOption) error {
+	if out == "" {
+		out = in + ".enc"
+	}
+	if err := configmgr.EncryptFile(in, out, secret, opts...); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "encrypted %s -> %s\n", in, out)
+	return nil
+}
+
+// encryptFields encrypts the values of in whose key matches keyRegex and
+// writes the document to out (default: in, rewritten in place).
+func encryptFields(in, out string, kr *configmgr.Keyring, keyRegex string, opts ...configmgr.EncryptOption) error {
+	if out == "" {
+		out = in
+	}
+	if err := configmgr.EncryptFieldsFile(in, out, kr, keyRegex, opts...); err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	fmt.Fprintf(os.Stderr, "encrypted values of %s -> %s\n", in, out)
+	return nil
+}
+
+// fieldEncrypted reports whether path is a YAML/JSON file with encrypted
+// values rather than a fully encrypted .enc file.
+func fieldEncrypted(path string) bool {
+	return !strings.HasSuffix(path, ".enc")
+}
+
+// openFile decrypts a .enc file or the values of a YAML/JSON file.
+func openFile(path string, data []byte, kr *configmgr.Keyring) ([]byte, error) {
+	if fieldEncrypted(path) {
+		return configmgr.DecryptFields(data, filepath.Ext(path), kr)
+	}
+	return kr.Decrypt(data)
+}
+
+// storedKeyRegex returns the key regex recorded in a document with encrypted values.
+func storedKeyRegex(data []byte) string {
+	var doc struct {
+		Meta struct {
+			Regex string `yaml:"encrypted_regex"`
+		} `yaml:"configmgr"`
+	}
+	_ = yaml.Unmarshal(data, &doc) // JSON is valid YAML
+	return doc.Meta.Regex
+}
+
+// decrypt writes in decrypted to out, or to stdout when out is empty. out is
+// replaced atomically and readable only by its owner.
+func decrypt(in, out string, kr *configmgr.Keyring) error {
+	switch {
+	case out != "" && fieldEncrypted(in):
+		return configmgr.DecryptFieldsFile(in, out, kr)
+	case out != "":
+		return configmgr.DecryptFileWithKeyring(in, out, kr)
+	}
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	plaintext, err := openFile(in, data, kr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	_, err = os.Stdout.Write(plaintext)
+	return err
+}
+
+// edit decrypts path into a private temporary file, opens it in $EDITOR and
+// re-encrypts it when it was changed. A missing path starts from an empty file.
+// The edited config must still parse; otherwise the editor can be reopened.
+// Files without the .enc suffix have their values encrypted, selected by
+// keyRegex or the regex stored in the file. When re-encrypting fails, the
+// temporary file is kept and its path reported.
+func edit(path string, kr *configmgr.Keyring, keyRegex string, opts ...configmgr.EncryptOption) error {
+	var plaintext []byte
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if plaintext, err = openFile(path, data, kr); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if keyRegex == "" {
+			keyRegex = storedKeyRegex(data)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if fieldEncrypted(path) && keyRegex == "" {
+		return fmt.Errorf("%s: no key regex stored in the file, give one with -key-regex", path)
+	}
+
+	dir, err := os.MkdirTemp("", "configctl-*")
+	if err != nil {
+		return err
+	}
+	keep := false // the edits, when they could not be saved
+	defer func() {
+		if !keep {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	// keep the plaintext extension so editors highlight it and LoadFromFile can parse it
+	tmp := filepath.Join(dir, strings.TrimSuffix(filepath.Base(path), ".enc"))
+	if err := os.WriteFile(tmp, plaintext, 0600); err != nil {
+		return err
+	}
+
+	for {
+		if err := runEditor(tmp); err != nil {
+			return err
+		}
+		edited, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(edited, plaintext) {
+			fmt.Fprintln(os.Stderr, "no changes")
+			return nil
+		}
+		perr := configmgr.NewConfigManager().LoadFromFile(tmp)
+		if perr == nil {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "invalid config: %v\n", perr)
+		if !confirm("Reopen the editor?") {
+			return errors.New("aborted, changes discarded")
+		}
+	}
+
+	if fieldEncrypted(path) {
+		err = configmgr.EncryptFieldsFile(tmp, path, kr, keyRegex, opts...)
+	} else {
+		var primary configmgr.Key
+		if primary, err = kr.Primary(); err == nil {
+			err = configmgr.EncryptFile(tmp, path, primary.Secret, opts...)
+		}
+	}
+	if err != nil {
+		keep = true
+		return fmt.Errorf("%w (edits kept in %s)", err, tmp)
+	}
+	fmt.Fprintf(os.Stderr, "saved %s\n", path)
+	return nil
+}
+
+// migrate re-encrypts a file written in an older format version.
+func migrate(path, secret string, opts ...configmgr.EncryptOption) error {
+	migrated, err := configmgr.MigrateFile(path, secret, opts...)
+	if err != nil {
+		return err
+	}
+	if !migrated {
+		fmt.Fprintf(os.Stderr, "%s is already in format version %d\n", path, configmgr.EncryptionFormatVersion)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "migrated %s to format version %d\n", path, configmgr.EncryptionFormatVersion)
+	return nil
+}
+
+// rotate re-encrypts files with the primary key. With dryRun it only reports
+// which files would change; every file must be decryptable either way.
+func rotate(files []string, kr *configmgr.Keyring, dryRun bool, opts ...configmgr.EncryptOption) error {
+	failed := 0
+	for _, path := range files {
+		var changed bool
+		var err error
+		if dryRun {
+			var data []byte
+			if data, err = os.ReadFile(path); err == nil {
+				changed, err = kr.NeedsRotation(data)
+			}
+		} else {
+			changed, err = configmgr.RotateFile(path, kr, opts...)
+		}
+
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		case changed && dryRun:
+			fmt.Fprintf(os.Stderr, "%s: would rotate\n", path)
+		case changed:
+			fmt.Fprintf(os.Stderr, "%s: rotated\n", path)
+		default:
+			fmt.Fprintf(os.Stderr, "%s: up to date\n", path)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed", failed, len(files))
+	}
+	return nil
+}
+
+// runEditor opens path in $EDITOR (default vi), which may include arguments, e.g. "code --wait".
+func runEditor(path string) error {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s: %w", editor[0], err)
+	}
+	return nil
+}
+
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [Y/n] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
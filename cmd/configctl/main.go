@@ -4,28 +4,133 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/Serajian/go-configmgr/configmgr"
 )
 
+// Exit codes of -action=validate.
+const (
+	exitOK      = 0 // config is valid
+	exitInvalid = 1 // config violates the schema or misses required keys
+	exitError   = 2 // config or schema could not be loaded, or bad usage
+)
+
+// The action is given with -action or as the first argument:
+//
+//	configctl -action=validate -schema=config.schema.json
+//	configctl encrypt -in=config.yaml
 func main() {
-	action := flag.String("action", "show", "action: show | validate")
+	action := flag.String("action", "show", "action: show | validate | encrypt | decrypt | edit | migrate | rotate")
 	envKey := flag.String("env", "APP_ENV", "profile environment key")
-	baseConf := flag.String("conf", "config.yaml", "base config file (yaml/json/.env)")
-	flag.Parse()
-
-	cm := configmgr.NewConfigManager()
+	baseConf := flag.String("conf", "config.yaml", "base config file (yaml/json/toml/.env)")
+	schemaFile := flag.String("schema", "", "validate: JSON schema file")
+	require := flag.String("require", "", "validate: comma-separated keys that must be set, e.g. database.host,APP_PORT")
+	strict := flag.Bool("strict", false, "validate: report keys the schema does not declare")
+	format := flag.String("format", "text", "validate: output format: text | json")
+	in := flag.String("in", "", "encrypt/decrypt/edit/migrate: input file; rotate: comma-separated files (or pass them as arguments)")
+	out := flag.String("out", "", "encrypt: output file (default <in>.enc); decrypt: output file (default stdout)")
+	keyEnv := flag.String("key-env", "CONFIG_SECRET_KEY", "encryption: environment variable holding the (new) secret")
+	keyFile := flag.String("key-file", "", "encryption: read the (new) secret from this file instead of -key-env")
+	keyCmd := flag.String("key-cmd", "", "encryption: read the (new) secret from the output of this command instead of -key-env")
+	keyID := flag.String("key-id", "", "encrypt/edit/rotate: id of the -key-env key, recorded in the file header")
+	oldKeyEnv := flag.String("old-key-env", "", "decrypt/edit/rotate: comma-separated environment variables holding older secrets")
+	keyRegex := flag.String("key-regex", "", "encrypt/edit: encrypt only the values whose key matches this regex, keeping the file readable")
+	kdf := flag.String("kdf", "argon2id", "encrypt/edit/migrate/rotate: key derivation function: argon2id | scrypt")
+	secrets := flag.String("secret", "", "show: comma-separated key patterns whose values are masked, e.g. *password*,database.dsn")
+	dryRun := flag.Bool("dry-run", false, "rotate: only report which files would be re-encrypted")
+	flag.Var(&configmgr.KeyValues{}, "set", "show/validate: override a config key, KEY=VALUE (repeatable), e.g. -set database.port=5433")
 
-	if err := cm.LoadWithProfile(*envKey, *baseConf); err != nil {
-		log.Fatal(err)
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		*action = os.Args[1]
+		_ = flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
 	}
 
+	cm := configmgr.NewConfigManager()
+
 	switch *action {
 	case "show":
+		if err := cm.MarkSecret(splitList(*secrets)...); err != nil {
+			log.Fatal(err)
+		}
+		if err := cm.LoadWithProfile(*envKey, *baseConf); err != nil {
+			log.Fatal(err)
+		}
+		if err := cm.LoadFromFlags(flag.CommandLine, "set"); err != nil {
+			log.Fatal(err)
+		}
 		data, _ := cm.ToJSON()
 		fmt.Println(string(data))
 
+	case "validate":
+		if *format != "text" && *format != "json" {
+			fmt.Fprintf(os.Stderr, "unknown format: %s\n", *format)
+			os.Exit(exitError)
+		}
+		if *strict && *schemaFile == "" {
+			fmt.Fprintln(os.Stderr, "-strict needs -schema")
+			os.Exit(exitError)
+		}
+		errs, code := validate(cm, *envKey, *baseConf, *schemaFile, *require, *strict)
+		report(errs, *format)
+		os.Exit(code)
+
+	case "encrypt", "decrypt", "edit", "migrate", "rotate":
+		files := splitList(*in)
+		if *action == "rotate" {
+			files = append(files, flag.Args()...)
+		}
+		if len(files) == 0 {
+			log.Fatalf("%s: -in is required", *action)
+		}
+		kr, err := keyring(*keyEnv, *keyFile, *keyCmd, *keyID, *oldKeyEnv)
+		if err != nil {
+			log.Fatalf("%s: %v", *action, err)
+		}
+		primary, err := kr.Primary()
+		if err != nil {
+			log.Fatalf("%s: %v", *action, err)
+		}
+		opts, err := kdfOptions(*kdf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, configmgr.WithKeyID(*keyID))
+		switch *action {
+		case "encrypt":
+			if *keyRegex != "" {
+				err = encryptFields(*in, *out, kr, *keyRegex, opts...)
+			} else {
+				err = encrypt(*in, *out, primary.Secret, opts...)
+			}
+		case "decrypt":
+			err = decrypt(*in, *out, kr)
+		case "edit":
+			err = edit(*in, kr, *keyRegex, opts...)
+		case "migrate":
+			err = migrate(*in, primary.Secret, opts...)
+		default:
+			err = rotate(files, kr, *dryRun, opts...)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
 	default:
 		log.Fatalf("unknown action: %s", *action)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
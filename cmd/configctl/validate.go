@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Serajian/go-configmgr/configmgr"
+)
+
+// validate loads the config and checks it. Load failures are reported with rule "load".
+// With strict, keys the schema does not declare are reported with rule "unused".
+func validate(cm *configmgr.ConfigManager, envKey, baseConf, schemaFile, require string, strict bool) ([]configmgr.FieldError, int) {
+	if err := cm.LoadWithProfile(envKey, baseConf); err != nil {
+		return []configmgr.FieldError{{Rule: "load", Message: err.Error()}}, exitError
+	}
+	if err := cm.LoadFromFlags(flag.CommandLine, "set"); err != nil {
+		return []configmgr.FieldError{{Rule: "load", Message: err.Error()}}, exitError
+	}
+
+	var errs []configmgr.FieldError
+	if schemaFile != "" {
+		schema, err := configmgr.LoadSchemaFile(schemaFile)
+		if err != nil {
+			return []configmgr.FieldError{{Rule: "load", Message: err.Error()}}, exitError
+		}
+		errs = append(errs, cm.ValidateSchema(schema)...)
+		if strict {
+			for _, p := range cm.UnusedKeys(schema) {
+				errs = append(errs, configmgr.FieldError{
+					Key: p.Key, Source: p.Origin.Kind, Path: p.Origin.Path, Line: p.Origin.Line,
+					Rule: "unused", Message: "key is not declared in the schema",
+				})
+			}
+		}
+	}
+	if keys := splitList(require); len(keys) > 0 {
+		errs = append(errs, cm.CheckRequired(keys...)...)
+	}
+
+	if len(errs) > 0 {
+		return errs, exitInvalid
+	}
+	return nil, exitOK
+}
+
+func report(errs []configmgr.FieldError, format string) {
+	if format == "json" {
+		out := struct {
+			Valid  bool                   `json:"valid"`
+			Errors []configmgr.FieldError `json:"errors"`
+		}{Valid: len(errs) == 0, Errors: errs}
+		if out.Errors == nil {
+			out.Errors = []configmgr.FieldError{}
+		}
+		data, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	if len(errs) == 0 {
+		fmt.Println("config is valid")
+		return
+	}
+	fmt.Fprint(os.Stderr, (&configmgr.ConfigError{Errors: errs}).Pretty())
+}
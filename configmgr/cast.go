@@ -0,0 +1,273 @@
+package configmgr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are tried in order when converting a string to time.Time.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func conversionError(v interface{}, to string) error {
+	return fmt.Errorf("cannot convert %v (%T) to %s", v, v, to)
+}
+
+func toString(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case []byte:
+		return string(t), nil
+	case fmt.Stringer:
+		return t.String(), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(t), nil
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	default:
+		return "", conversionError(v, "string")
+	}
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch t := v.(type) {
+	case int:
+		return int64(t), nil
+	case int8:
+		return int64(t), nil
+	case int16:
+		return int64(t), nil
+	case int32:
+		return int64(t), nil
+	case int64:
+		return t, nil
+	case uint:
+		return uintToInt64(uint64(t))
+	case uint8:
+		return int64(t), nil
+	case uint16:
+		return int64(t), nil
+	case uint32:
+		return int64(t), nil
+	case uint64:
+		return uintToInt64(t)
+	case float32:
+		return floatToInt64(float64(t))
+	case float64:
+		return floatToInt64(t)
+	case bool:
+		if t {
+			return 1, nil
+		}
+		return 0, nil
+	case time.Duration:
+		return int64(t), nil
+	case string:
+		s := strings.TrimSpace(t)
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return floatToInt64(f)
+		}
+		return 0, conversionError(v, "int64")
+	default:
+		return 0, conversionError(v, "int64")
+	}
+}
+
+func uintToInt64(u uint64) (int64, error) {
+	if u > math.MaxInt64 {
+		return 0, fmt.Errorf("value %d overflows int64", u)
+	}
+	return int64(u), nil
+}
+
+func floatToInt64(f float64) (int64, error) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f > math.MaxInt64 {
+		return 0, fmt.Errorf("value %v is not an integer", f)
+	}
+	return int64(f), nil
+}
+
+func toInt(v interface{}) (int, error) {
+	i, err := toInt64(v)
+	if err != nil {
+		return 0, err
+	}
+	if i < math.MinInt || i > math.MaxInt {
+		return 0, fmt.Errorf("value %d overflows int", i)
+	}
+	return int(i), nil
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case float32:
+		return float64(t), nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+		if err != nil {
+			return 0, conversionError(v, "float64")
+		}
+		return f, nil
+	default:
+		i, err := toInt64(v)
+		if err != nil {
+			return 0, conversionError(v, "float64")
+		}
+		return float64(i), nil
+	}
+}
+
+func toBool(v interface{}) (bool, error) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case string:
+		s := strings.ToLower(strings.TrimSpace(t))
+		switch s {
+		case "yes", "y", "on":
+			return true, nil
+		case "no", "n", "off", "":
+			return false, nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return false, conversionError(v, "bool")
+		}
+		return b, nil
+	default:
+		i, err := toInt64(v)
+		if err != nil {
+			return false, conversionError(v, "bool")
+		}
+		return i != 0, nil
+	}
+}
+
+// toDuration accepts time.Duration, Go duration strings ("5s", "1h30m")
+// and plain numbers, which are interpreted as seconds.
+func toDuration(v interface{}) (time.Duration, error) {
+	switch t := v.(type) {
+	case time.Duration:
+		return t, nil
+	case string:
+		s := strings.TrimSpace(t)
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return time.Duration(f * float64(time.Second)), nil
+		}
+		return 0, conversionError(v, "time.Duration")
+	default:
+		f, err := toFloat64(v)
+		if err != nil {
+			return 0, conversionError(v, "time.Duration")
+		}
+		return time.Duration(f * float64(time.Second)), nil
+	}
+}
+
+// toTime accepts time.Time, RFC 3339 and common date layouts, and Unix seconds.
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		s := strings.TrimSpace(t)
+		for _, layout := range timeLayouts {
+			if tm, err := time.Parse(layout, s); err == nil {
+				return tm, nil
+			}
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(i, 0).UTC(), nil
+		}
+		return time.Time{}, conversionError(v, "time.Time")
+	default:
+		i, err := toInt64(v)
+		if err != nil {
+			return time.Time{}, conversionError(v, "time.Time")
+		}
+		return time.Unix(i, 0).UTC(), nil
+	}
+}
+
+// toStringSlice accepts lists of scalars and comma-separated strings.
+func toStringSlice(v interface{}) ([]string, error) {
+	switch t := v.(type) {
+	case []string:
+		return append([]string(nil), t...), nil
+	case []interface{}:
+		out := make([]string, 0, len(t))
+		for _, e := range t {
+			s, err := toString(e)
+			if err != nil {
+				return nil, conversionError(v, "[]string")
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	case string:
+		if strings.TrimSpace(t) == "" {
+			return []string{}, nil
+		}
+		parts := strings.Split(t, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		return parts, nil
+	default:
+		s, err := toString(v)
+		if err != nil {
+			return nil, conversionError(v, "[]string")
+		}
+		return []string{s}, nil
+	}
+}
+
+// toStringMap accepts nested maps and "k1=v1,k2=v2" strings.
+func toStringMap(v interface{}) (map[string]interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return copyMap(t), nil
+	case map[string]string:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			out[k] = e
+		}
+		return out, nil
+	case string:
+		out := make(map[string]interface{})
+		for _, pair := range strings.Split(t, ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			k, e, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, conversionError(v, "map[string]interface{}")
+			}
+			out[strings.TrimSpace(k)] = strings.TrimSpace(e)
+		}
+		return out, nil
+	default:
+		return nil, conversionError(v, "map[string]interface{}")
+	}
+}
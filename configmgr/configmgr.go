@@ -1,37 +1,183 @@
 package configmgr
 
 import (
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // ConfigManager is the core configuration manager.
+//
+// Nested maps from JSON/YAML files are kept as a tree, and every key accepts
+// a delimited path (see SetKeyDelimiter), e.g. Get("database.host").
+//
+// All methods are safe for concurrent use.
 type ConfigManager struct {
-	data   map[string]interface{}
-	logger Logger
+	mu        sync.RWMutex
+	data      map[string]interface{} // raw, or resolved with interpolation
+	raw       map[string]interface{} // merged layers before interpolation
+	delimiter string
+	logger    Logger
+	autoEnv   bool
+	envPrefix string
+	keys      KeyProvider
+
+	envFiles    bool // see EnableEnvFiles
+	interpolate bool
+	derived     map[string]bool          // pathKeys of values interpolated from secrets
+	secrets     map[string]secretPattern // see MarkSecret
+
+	layers     []*layer
+	provenance map[string]*Provenance
+	onChange   []func(old, new Snapshot)
+	reloadMu   sync.Mutex
+	watching   bool
 }
 
 // NewConfigManager creates a new ConfigManager instance.
 func NewConfigManager() *ConfigManager {
-	return &ConfigManager{data: make(map[string]interface{})}
+	data := make(map[string]interface{})
+	return &ConfigManager{
+		data:       data,
+		raw:        data,
+		delimiter:  DefaultKeyDelimiter,
+		provenance: make(map[string]*Provenance),
+	}
 }
 
 // Get returns a raw value from config data.
+// Nested values are addressed with a key path, e.g. "database.host".
+// Secret values are returned as Secret.
 func (cm *ConfigManager) Get(key string) interface{} {
-	return cm.data[normalizeKey(key)]
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	path := cm.keyPath(key)
+	v, _ := cm.value(path)
+	return cm.mask(v, path)
+}
+
+// lookup returns the value stored at key and whether it is set.
+// With AutomaticEnv, a matching environment variable takes precedence.
+func (cm *ConfigManager) lookup(key string) (interface{}, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.value(cm.keyPath(key))
+}
+
+// value is lookup for a split key path. The caller must hold cm.mu.
+func (cm *ConfigManager) value(path []string) (interface{}, bool) {
+	if v, ok := cm.envOverride(path); ok {
+		return v, true
+	}
+	return lookupPath(cm.data, path)
 }
 
 // Set sets a config value manually.
+// A key path such as "database.pool.max" creates the intermediate maps.
+// Set values are part of the source stack and survive a Reload.
+// Setting a Secret stores its value and marks key secret.
+// With EnableInterpolation, a value whose references cannot be resolved is
+// not set and the error is logged.
 func (cm *ConfigManager) Set(key string, value interface{}) {
-	cm.data[normalizeKey(key)] = normalizeValue(value)
+	s, secret := value.(Secret)
+	if secret {
+		value = s.value
+	}
+	value = normalizeValue(value)
+	cm.mu.Lock()
+	path := cm.splitKey(key)
+	if secret {
+		cm.addSecret(secretPattern{segs: path})
+	}
+	prev := append([]*layer(nil), cm.layers...)
+	prevTrees := make(map[*layer]map[string]interface{})
+	err := cm.setValue(path, value, prevTrees)
+	if err != nil {
+		// keep the configuration as it was
+		cm.layers = prev
+		for l, tree := range prevTrees {
+			l.tree = tree
+		}
+		_ = cm.rebuild()
+	}
+	cm.mu.Unlock()
+	if err != nil {
+		cm.logError("interpolation_failed", err, map[string]interface{}{"key": key})
+	}
 }
 
-// GetAll returns all config data.
+// setValue stores value at path for Set. Earlier Set values of path are
+// dropped, and the value goes to the last layer of the default priority when
+// that is a Set layer, so repeated Set calls do not grow the source stack.
+// The trees of changed layers are recorded in prev. The caller must hold cm.mu.
+func (cm *ConfigManager) setValue(path []string, value interface{}, prev map[*layer]map[string]interface{}) error {
+	end := sort.Search(len(cm.layers), func(i int) bool { return cm.layers[i].priority > 0 })
+	var top *layer
+	if end > 0 && isSetLayer(cm.layers[end-1]) {
+		top = cm.layers[end-1]
+	}
+	layers := cm.layers[:0]
+	for _, l := range cm.layers {
+		if isSetLayer(l) && l != top {
+			if _, ok := lookupPath(l.tree, path); ok {
+				prev[l] = l.tree
+				l.tree = copyMap(l.tree)
+				deletePath(l.tree, path)
+				if len(l.tree) == 0 {
+					continue
+				}
+			}
+		}
+		layers = append(layers, l)
+	}
+	cm.layers = layers
+
+	if top == nil {
+		tree := make(map[string]interface{})
+		setPath(tree, path, value)
+		return cm.insertLayer(&layer{kind: "set", tree: tree})
+	}
+	prev[top] = top.tree
+	top.tree = copyMap(top.tree)
+	setPath(top.tree, path, value)
+	if _, isMap := value.(map[string]interface{}); isMap || top != cm.layers[len(cm.layers)-1] {
+		return cm.rebuild()
+	}
+	// the value replaces whatever is at path: merge it like a new layer
+	tree := make(map[string]interface{})
+	setPath(tree, path, value)
+	cm.mergeLayer(&layer{kind: top.kind, tree: tree})
+	return cm.resolve()
+}
+
+func isSetLayer(l *layer) bool { return l.src == nil && l.kind == "set" }
+
+// GetAll returns a deep copy of all config data, with secret values as Secret.
+// Mutating the returned map does not affect the manager.
 func (cm *ConfigManager) GetAll() map[string]interface{} {
-	return cm.data
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.mask(cm.view(), nil).(map[string]interface{})
+}
+
+// view returns a deep copy of the config data with AutomaticEnv overrides
+// applied to every leaf key. The caller must hold cm.mu.
+func (cm *ConfigManager) view() map[string]interface{} {
+	data := copyMap(cm.data)
+	if !cm.autoEnv {
+		return data
+	}
+	for _, leaf := range leafPaths(data, nil) {
+		if v, ok := cm.envOverride(leaf); ok {
+			setPath(data, leaf, v)
+		}
+	}
+	return data
 }
 
 // normalizeKey ensures all keys are stored in uppercase.
+// Key paths are upper-cased as a whole, so every segment is normalized.
 func normalizeKey(key string) string {
 	return strings.ToUpper(key)
 }
@@ -61,6 +207,19 @@ func normalizeValue(value interface{}) interface{} {
 		}
 		return v
 
+	case map[string]interface{}:
+		return normalizeTree(v)
+
+	case map[interface{}]interface{}:
+		return normalizeTree(stringKeyMap(v))
+
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = normalizeValue(e)
+		}
+		return out
+
 	default:
 		return value
 	}
@@ -0,0 +1,373 @@
+package configmgr
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DecodeHook converts a raw config value before it is decoded into a value of
+// type to. It returns the value to decode instead, or value itself to leave it
+// to the default decoding. Hooks run in registration order.
+type DecodeHook func(value interface{}, to reflect.Type) (interface{}, error)
+
+// UnmarshalOption configures Unmarshal.
+type UnmarshalOption func(*decoder)
+
+// WithDecodeHook registers a hook used for every decoded value.
+func WithDecodeHook(h DecodeHook) UnmarshalOption {
+	return func(d *decoder) { d.hooks = append(d.hooks, h) }
+}
+
+// decoder decodes config trees into Go values by reflection.
+type decoder struct {
+	hooks  []DecodeHook
+	strict bool    // fail on keys no field consumes, see Strict
+	errs   []error // decode errors of the fields, see decodeStruct
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	urlType             = reflect.TypeOf(url.URL{})
+)
+
+// fieldPlan describes how one struct field is decoded.
+type fieldPlan struct {
+	index  int
+	name   string // Go field name, used in error paths
+	label  string // config key as written in the tag
+	key    string // normalized config key
+	squash bool   // embedded struct decoded from the parent map
+}
+
+// structPlans caches the field plans of every decoded struct type.
+var structPlans sync.Map // map[reflect.Type][]fieldPlan
+
+// planFor returns the cached field plan of a struct type.
+//
+// Keys come from the `config` tag, then the `json` tag, then the field name.
+// A "-" name skips the field. Embedded structs without a name, or tagged
+// `,squash`, read their fields from the parent map.
+func planFor(t reflect.Type) []fieldPlan {
+	if p, ok := structPlans.Load(t); ok {
+		return p.([]fieldPlan)
+	}
+	var plan []fieldPlan
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag, ok := f.Tag.Lookup("config")
+		if !ok {
+			tag = f.Tag.Get("json")
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		fp := fieldPlan{index: i, name: f.Name, label: name}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && ft.Kind() == reflect.Struct && (name == "" || hasTagOption(opts, "squash")) {
+			fp.squash = true
+		} else if !f.IsExported() {
+			continue
+		}
+		if fp.label == "" {
+			fp.label = f.Name
+		}
+		fp.key = normalizeKey(fp.label)
+		plan = append(plan, fp)
+	}
+	p, _ := structPlans.LoadOrStore(t, plan)
+	return p.([]fieldPlan)
+}
+
+func hasTagOption(opts, want string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == want {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeStruct fills the fields of struct v from a config tree.
+// Fields without a matching key are left untouched. A field that fails to
+// decode is recorded in d.errs and the remaining fields are still decoded.
+func (d *decoder) decodeStruct(v reflect.Value, tree map[string]interface{}, path string) {
+	for _, fp := range planFor(v.Type()) {
+		field := v.Field(fp.index)
+		fieldPath := joinFieldPath(path, fp.name)
+
+		if fp.squash {
+			if field.Kind() == reflect.Ptr {
+				if !field.CanSet() {
+					continue
+				}
+				if field.IsNil() {
+					field.Set(reflect.New(field.Type().Elem()))
+				}
+				field = field.Elem()
+			}
+			d.decodeStruct(field, tree, path)
+			continue
+		}
+
+		raw, ok := tree[fp.key]
+		if !ok || !field.CanSet() {
+			continue
+		}
+		if err := d.decode(field, raw, fieldPath); err != nil {
+			d.errs = append(d.errs, err)
+		}
+	}
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// decode converts raw into v, leniently converting between representations.
+// Like struct fields, the elements of slices, arrays and maps that fail to
+// decode are recorded in d.errs and the remaining elements are still decoded.
+func (d *decoder) decode(v reflect.Value, raw interface{}, path string) error {
+	for _, hook := range d.hooks {
+		converted, err := hook(raw, v.Type())
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		raw = converted
+	}
+	if raw == nil {
+		return nil
+	}
+
+	t := v.Type()
+	rv := reflect.ValueOf(raw)
+	if rv.Type() == t && t.Kind() != reflect.Map && t.Kind() != reflect.Slice {
+		v.Set(rv)
+		return nil
+	}
+
+	switch t {
+	case secretType:
+		v.Set(reflect.ValueOf(Secret{value: copyValue(raw)}))
+		return nil
+	case durationType:
+		dur, err := toDuration(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		v.SetInt(int64(dur))
+		return nil
+	case timeType:
+		tm, err := toTime(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		v.Set(reflect.ValueOf(tm))
+		return nil
+	case urlType:
+		s, err := toString(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	if t.Kind() != reflect.Ptr && reflect.PointerTo(t).Implements(textUnmarshalerType) && isScalar(raw) {
+		s, err := toString(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return decodeError(path, raw, err)
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return d.decode(v.Elem(), raw, path)
+
+	case reflect.Interface:
+		if !rv.Type().AssignableTo(t) {
+			return decodeError(path, raw, fmt.Errorf("cannot assign %T to %s", raw, t))
+		}
+		v.Set(reflect.ValueOf(copyValue(raw)))
+		return nil
+
+	case reflect.String:
+		s, err := toString(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, err := toBool(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := toInt64(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		if v.OverflowInt(i) {
+			return decodeError(path, raw, fmt.Errorf("value %d overflows %s", i, t))
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, err := toInt64(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return decodeError(path, raw, fmt.Errorf("value %d overflows %s", i, t))
+		}
+		v.SetUint(uint64(i))
+
+	case reflect.Float32, reflect.Float64:
+		f, err := toFloat64(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		if v.OverflowFloat(f) {
+			return decodeError(path, raw, fmt.Errorf("value %v overflows %s", f, t))
+		}
+		v.SetFloat(f)
+
+	case reflect.Slice:
+		if s, ok := raw.(string); ok && t.Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+		items, err := toItems(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		out := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := d.decode(out.Index(i), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				d.errs = append(d.errs, err)
+			}
+		}
+		v.Set(out)
+
+	case reflect.Array:
+		items, err := toItems(raw)
+		if err != nil {
+			return decodeError(path, raw, err)
+		}
+		if len(items) > v.Len() {
+			return decodeError(path, raw, fmt.Errorf("%d values do not fit in %s", len(items), t))
+		}
+		for i, item := range items {
+			if err := d.decode(v.Index(i), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				d.errs = append(d.errs, err)
+			}
+		}
+
+	case reflect.Map:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return decodeError(path, raw, fmt.Errorf("cannot decode %T into %s", raw, t))
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // report element errors in a stable order
+		out := reflect.MakeMapWithSize(t, len(m))
+		for _, k := range keys {
+			e := m[k]
+			key := reflect.New(t.Key()).Elem()
+			if err := d.decode(key, k, fmt.Sprintf("%s[%s]", path, k)); err != nil {
+				d.errs = append(d.errs, err)
+				continue
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := d.decode(elem, e, fmt.Sprintf("%s[%s]", path, k)); err != nil {
+				d.errs = append(d.errs, err)
+				continue
+			}
+			out.SetMapIndex(key, elem)
+		}
+		v.Set(out)
+
+	case reflect.Struct:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return decodeError(path, raw, fmt.Errorf("cannot decode %T into %s", raw, t))
+		}
+		d.decodeStruct(v, m, path)
+		return nil
+
+	default:
+		return decodeError(path, raw, fmt.Errorf("unsupported kind %s", t.Kind()))
+	}
+	return nil
+}
+
+// toItems returns the elements of a list value. Strings are split on commas
+// (as for env variables) and any other scalar becomes a single element.
+func toItems(raw interface{}) ([]interface{}, error) {
+	switch t := raw.(type) {
+	case []interface{}:
+		return t, nil
+	case string:
+		parts, _ := toStringSlice(t)
+		items := make([]interface{}, len(parts))
+		for i, p := range parts {
+			items[i] = p
+		}
+		return items, nil
+	case map[string]interface{}:
+		return nil, fmt.Errorf("cannot decode a map into a list")
+	default:
+		rv := reflect.ValueOf(raw)
+		if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			items := make([]interface{}, rv.Len())
+			for i := range items {
+				items[i] = rv.Index(i).Interface()
+			}
+			return items, nil
+		}
+		return []interface{}{raw}, nil
+	}
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	default:
+		return true
+	}
+}
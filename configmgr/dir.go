@@ -0,0 +1,73 @@
+package configmgr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadDir loads every config file of dir in lexical order, so later files
+// override earlier ones: 10-base.yaml, 20-db.toml, 90-local.env, ...
+//
+// JSON, YAML, TOML, .env and encrypted (.enc) files are loaded; other files,
+// hidden files and subdirectories are ignored. Encrypted files are decrypted
+// with the keys of SetKeyring or SetKeyProvider.
+func (cm *ConfigManager) LoadDir(dir string) error {
+	if err := cm.Load(context.Background(), DirSource(dir)); err != nil {
+		return err
+	}
+	cm.logInfo("load_dir_success", map[string]interface{}{"path": dir})
+	return nil
+}
+
+// DirSource returns a Source for the config files of a directory, as described
+// for LoadDir. When loaded through Load, every file is a separate layer.
+// The directory is listed again on every Reload, and Watch reloads when files
+// are added or removed.
+func DirSource(dir string) Source {
+	return &dirSource{dir: dir}
+}
+
+type dirSource struct {
+	dir string
+}
+
+func (s *dirSource) Name() string { return "dir:" + s.dir }
+
+func (s *dirSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return mergeSources(ctx, s)
+}
+
+func (s *dirSource) Paths() []string {
+	paths := []string{s.dir} // its modification time changes when files are added or removed
+	children, _ := s.expand()
+	for _, child := range children {
+		paths = append(paths, child.(WatchableSource).Paths()...)
+	}
+	return paths
+}
+
+func (s *dirSource) expand() ([]Source, error) {
+	entries, err := os.ReadDir(s.dir) // sorted by name
+	if err != nil {
+		return nil, err
+	}
+	var sources []Source
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(s.dir, name)
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".json", ".yaml", ".yml", ".toml":
+			sources = append(sources, FileSource(path))
+		case ".env":
+			sources = append(sources, DotEnvSource(path))
+		case ".enc":
+			sources = append(sources, &encryptedFileSource{path: path})
+		}
+	}
+	return sources, nil
+}
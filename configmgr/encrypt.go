@@ -1,73 +1,243 @@
 package configmgr
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/sha256"
+	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
-
-	"gopkg.in/yaml.v3"
 )
 
 // LoadEncryptedFile loads and decrypts an encrypted config file (AES-256).
 func (cm *ConfigManager) LoadEncryptedFile(path, secret string) error {
-	data, err := os.ReadFile(path)
+	return cm.Load(context.Background(), EncryptedFileSource(path, secret))
+}
+
+// LoadEncryptedFileWithKeys loads an encrypted config file with the keys of p,
+// e.g. EnvKey("CONFIG_SECRET_KEY"), FileKey("/run/secrets/config") or KMSKey(...).
+func (cm *ConfigManager) LoadEncryptedFileWithKeys(path string, p KeyProvider) error {
+	return cm.Load(context.Background(), EncryptedFileSourceWithKeys(path, p))
+}
+
+// LoadEncryptedFileFS is LoadEncryptedFile for a file of fsys.
+func (cm *ConfigManager) LoadEncryptedFileFS(fsys fs.FS, path, secret string) error {
+	return cm.Load(context.Background(), EncryptedFileSourceFS(fsys, path, secret))
+}
+
+// EncryptedFileSource returns a Source reading an encrypted JSON, YAML or TOML file
+// (.json.enc, .yaml.enc, .yml.enc, .toml.enc).
+func EncryptedFileSource(path, secret string) Source {
+	return &encryptedFileSource{path: path, keys: &Keyring{keys: []Key{{Secret: secret}}}}
+}
+
+// EncryptedFileSourceFS is EncryptedFileSource for a file of fsys. Files of an
+// fs.FS are not watched.
+func EncryptedFileSourceFS(fsys fs.FS, path, secret string) Source {
+	return &encryptedFileSource{fsys: fsys, path: path, keys: &Keyring{keys: []Key{{Secret: secret}}}}
+}
+
+// EncryptedFileSourceWithKeyring is like EncryptedFileSource, decrypting with any key of kr.
+func EncryptedFileSourceWithKeyring(path string, kr *Keyring) Source {
+	return &encryptedFileSource{path: path, keys: kr}
+}
+
+// EncryptedFileSourceWithKeys is like EncryptedFileSource, asking p for the
+// keys on every load.
+func EncryptedFileSourceWithKeys(path string, p KeyProvider) Source {
+	return &encryptedFileSource{path: path, keys: p}
+}
+
+type encryptedFileSource struct {
+	fsys fs.FS // nil for the OS file system
+	path string
+	keys KeyProvider // nil for the keys of the manager
+}
+
+func (s *encryptedFileSource) Name() string                { return "encrypted:" + s.path }
+func (s *encryptedFileSource) Paths() []string             { return osPaths(s.fsys, s.path) }
+func (s *encryptedFileSource) origin() (kind, path string) { return "encrypted", s.path }
+
+func (s *encryptedFileSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *encryptedFileSource) load(ctx context.Context, opts loadOptions) (loaded, error) {
+	keys := s.keys
+	if keys == nil {
+		keys = opts.keys // e.g. files of DirSource
+	}
+	if keys == nil {
+		return loaded{}, fmt.Errorf("%s: no key to decrypt with, see SetKeyProvider", s.path)
+	}
+	return readEncryptedFile(ctx, s.fsys, s.path, keys)
+}
+
+// readEncryptedFile decrypts an encrypted JSON, YAML or TOML file into a normalized tree.
+func readEncryptedFile(ctx context.Context, fsys fs.FS, path string, p KeyProvider) (loaded, error) {
+	data, err := readFile(fsys, path)
 	if err != nil {
-		return err
+		return loaded{}, err
+	}
+	kr, err := p.Keys(ctx)
+	if err != nil {
+		return loaded{}, err
 	}
 
-	encBytes, err := base64.StdEncoding.DecodeString(string(data))
+	plaintext, err := kr.Decrypt(data)
 	if err != nil {
-		return err
+		return loaded{}, err
 	}
 
-	key := sha256.Sum256([]byte(secret))
+	switch ext := getEncryptedExt(path); ext {
+	case ".json.enc", ".yaml.enc", ".yml.enc", ".toml.enc":
+		return decodeConfig(ctx, plaintext, strings.TrimSuffix(ext, ".enc"), kr)
+	default:
+		return loaded{}, fmt.Errorf("unsupported encrypted file type: %s", ext)
+	}
+}
 
-	block, err := aes.NewCipher(key[:])
+// EncryptBytes encrypts plaintext with secret in the format read by
+// LoadEncryptedFile (see EncryptionFormatVersion). The key is derived with
+// Argon2id unless another KDF is chosen with opts.
+func EncryptBytes(plaintext []byte, secret string, opts ...EncryptOption) ([]byte, error) {
+	sealed, err := seal(plaintext, secret, newEncryptConfig(opts))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return out, nil
+}
+
+// DecryptBytes decrypts data produced by EncryptBytes, in any supported format version.
+func DecryptBytes(data []byte, secret string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return open(raw, secret)
+}
 
-	if len(encBytes) < gcm.NonceSize() {
-		return fmt.Errorf("ciphertext too short")
+// EncryptedVersion reports the format version of encrypted data; 0 is the
+// original headerless format.
+func EncryptedVersion(data []byte) (int, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, err
+	}
+	if bytes.HasPrefix(raw, envelopeMagic) && len(raw) > len(envelopeMagic) {
+		return int(raw[len(envelopeMagic)]), nil
 	}
+	return 0, nil
+}
 
-	nonce, ciphertext := encBytes[:gcm.NonceSize()], encBytes[gcm.NonceSize():]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+// EncryptedKeyID reports the key id recorded in the header of encrypted data,
+// or "" when there is none.
+func EncryptedKeyID(data []byte) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
 	if err != nil {
-		return fmt.Errorf("decryption failed: %w", err)
+		return "", err
 	}
+	return parseHeader(raw).keyID, nil
+}
 
-	ext := getEncryptedExt(path)
-	tmp := make(map[string]interface{})
+// EncryptFile encrypts the file src into dst (e.g. config.yaml into config.yaml.enc).
+// dst is replaced atomically; an existing dst keeps its permissions, a new one
+// is readable only by its owner.
+func EncryptFile(src, dst, secret string, opts ...EncryptOption) error {
+	plaintext, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	data, err := EncryptBytes(plaintext, secret, opts...)
+	if err != nil {
+		return err
+	}
+	return rewriteFile(dst, data)
+}
 
-	if ext == ".json.enc" {
-		if err = json.Unmarshal(plaintext, &tmp); err != nil {
-			return err
-		}
-	} else if ext == ".yaml.enc" || ext == ".yml.enc" {
-		if err = yaml.Unmarshal(plaintext, &tmp); err != nil {
-			return err
-		}
-	} else {
-		return fmt.Errorf("unsupported encrypted file type: %s", ext)
+// DecryptFile decrypts the file src into dst. dst is replaced atomically and
+// readable only by its owner.
+func DecryptFile(src, dst, secret string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	plaintext, err := DecryptBytes(data, secret)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
 	}
+	return writeFileAtomic(dst, plaintext, 0600)
+}
 
-	for k, v := range tmp {
-		cm.data[normalizeKey(k)] = normalizeValue(v)
+// MigrateFile re-encrypts an encrypted file written in an older format version
+// in place, keeping its permissions. It reports whether the file was rewritten.
+func MigrateFile(path, secret string, opts ...EncryptOption) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	version, err := EncryptedVersion(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	if version == EncryptionFormatVersion {
+		return false, nil
 	}
+	plaintext, err := DecryptBytes(data, secret)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	enc, err := EncryptBytes(plaintext, secret, opts...)
+	if err != nil {
+		return false, err
+	}
+	return true, rewriteFile(path, enc)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path, so readers (and Watch) never see a partially written file.
+// When path is a symlink, the file it points to is replaced.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
 
-	return nil
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// rewriteFile is writeFileAtomic for a file rewritten in place: an existing
+// file keeps its permissions, a new one is readable only by its owner.
+func rewriteFile(path string, data []byte) error {
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return writeFileAtomic(path, data, perm)
 }
 
 func getEncryptedExt(path string) string {
@@ -80,5 +250,8 @@ func getEncryptedExt(path string) string {
 	if strings.HasSuffix(path, ".yml.enc") {
 		return ".yml.enc"
 	}
+	if strings.HasSuffix(path, ".toml.enc") {
+		return ".toml.enc"
+	}
 	return filepath.Ext(path) // fallback
 }
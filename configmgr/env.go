@@ -1,7 +1,10 @@
 package configmgr
 
 import (
+	"context"
+	"io/fs"
 	"os"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -11,26 +14,222 @@ func (cm *ConfigManager) LoadFromDotEnv(path string) error {
 	if path == "" {
 		path = ".env"
 	}
-	envMap, err := godotenv.Read(path)
-	if err != nil {
+	if err := cm.Load(context.Background(), DotEnvSource(path)); err != nil {
 		return err
 	}
-	for k, v := range envMap {
-		_ = os.Setenv(k, v)
-		cm.data[normalizeKey(k)] = normalizeValue(v)
+	cm.logInfo("loaded env", map[string]interface{}{"path": path})
+	return nil
+}
+
+// LoadFromSysEnv loads a single environment variable into cm.data.
+// The value is kept verbatim; use the typed getters to convert it.
+func (cm *ConfigManager) LoadFromSysEnv(key string) error {
+	if err := cm.Load(context.Background(), SysEnvSource(key)); err != nil {
+		return err
 	}
-	if cm.logger != nil {
-		cm.logger.Info("loaded env", map[string]interface{}{"path": path})
+	cm.logInfo("loaded system env", map[string]interface{}{"key": key})
+	return nil
+}
+
+// LoadFromSysEnvPrefix loads every environment variable starting with prefix.
+// The prefix is stripped and "__" maps to nesting, so with prefix "MYAPP_"
+// MYAPP_DATABASE__HOST becomes the key "database.host".
+// Values are kept verbatim; use the typed getters to convert them.
+func (cm *ConfigManager) LoadFromSysEnvPrefix(prefix string) error {
+	if err := cm.Load(context.Background(), SysEnvPrefixSource(prefix)); err != nil {
+		return err
 	}
+	cm.logInfo("loaded system env", map[string]interface{}{"prefix": prefix})
 	return nil
 }
 
-// LoadFromSysEnv loads a single environment variable into cm.data.
-func (cm *ConfigManager) LoadFromSysEnv(key string) {
-	if val, ok := os.LookupEnv(key); ok {
-		cm.data[normalizeKey(key)] = normalizeKey(val)
+// AutomaticEnv makes environment variables override config keys at lookup time
+// (Get, the typed getters, GetAll, Unmarshal and exports). The variable for a
+// key is prefix plus the upper-cased key path with nested segments joined by "__":
+// with prefix "MYAPP_", "database.host" is read from MYAPP_DATABASE__HOST.
+// Keys set by command-line flags (LoadFromFlags) keep the flag value.
+func (cm *ConfigManager) AutomaticEnv(prefix string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.autoEnv = true
+	cm.envPrefix = prefix
+}
+
+// envNestingSeparator joins nested key segments in environment variable names.
+const envNestingSeparator = "__"
+
+// envOverride returns the AutomaticEnv value for a key path. The caller must hold cm.mu.
+func (cm *ConfigManager) envOverride(path []string) (string, bool) {
+	v, _, ok := cm.envOverrideVar(path)
+	return v, ok
+}
+
+// envOverrideVar is envOverride, also returning the variable the value was
+// read from. Keys set by command-line flags are not overridden.
+// The caller must hold cm.mu.
+func (cm *ConfigManager) envOverrideVar(path []string) (value, variable string, ok bool) {
+	if !cm.autoEnv || len(path) == 0 {
+		return "", "", false
 	}
-	if cm.logger != nil {
-		cm.logger.Info("loaded system env", map[string]interface{}{"key": key})
+	if p, ok := cm.provenance[pathKey(path)]; ok && p.Origin.Kind == flagKind {
+		return "", "", false
+	}
+	return lookupEnv(envVarName(cm.envPrefix, path), cm.envFiles)
+}
+
+// envVarName maps a key path to an environment variable name. Characters that
+// are not valid in variable names become "_".
+func envVarName(prefix string, path []string) string {
+	name := strings.Join(path, envNestingSeparator)
+	name = strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	return prefix + name
+}
+
+// LoadFromDotEnvFS is LoadFromDotEnv for a file of fsys.
+func (cm *ConfigManager) LoadFromDotEnvFS(fsys fs.FS, path string) error {
+	if path == "" {
+		path = ".env"
+	}
+	if err := cm.Load(context.Background(), DotEnvSourceFS(fsys, path)); err != nil {
+		return err
+	}
+	cm.logInfo("loaded env", map[string]interface{}{"path": path})
+	return nil
+}
+
+// DotEnvSource returns a Source reading a .env file.
+// Loading it also exports the variables to the process environment.
+func DotEnvSource(path string) Source {
+	return &dotEnvSource{path: path}
+}
+
+// DotEnvSourceFS is DotEnvSource for a file of fsys. Files of an fs.FS are not watched.
+func DotEnvSourceFS(fsys fs.FS, path string) Source {
+	return &dotEnvSource{fsys: fsys, path: path}
+}
+
+type dotEnvSource struct {
+	fsys fs.FS // nil for the OS file system
+	path string
+}
+
+func (s *dotEnvSource) Name() string                { return "dotenv:" + s.path }
+func (s *dotEnvSource) Paths() []string             { return osPaths(s.fsys, s.path) }
+func (s *dotEnvSource) origin() (kind, path string) { return "dotenv", s.path }
+
+func (s *dotEnvSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *dotEnvSource) load(_ context.Context, opts loadOptions) (loaded, error) {
+	raw, err := readFile(s.fsys, s.path)
+	if err != nil {
+		return loaded{}, err
+	}
+	return decodeDotEnv(raw, opts.delimiter)
+}
+
+// decodeDotEnv decodes a .env document into a normalized tree and exports
+// its variables to the process environment.
+func decodeDotEnv(raw []byte, delimiter string) (loaded, error) {
+	envMap, err := godotenv.UnmarshalBytes(raw)
+	if err != nil {
+		return loaded{}, err
+	}
+	tree := make(map[string]interface{}, len(envMap))
+	for k, v := range envMap {
+		_ = os.Setenv(k, v)
+		setPath(tree, splitKeyPath(k, delimiter), normalizeValue(v))
+	}
+	return loaded{tree: tree, lines: dotenvLines(raw, delimiter)}, nil
+}
+
+// SysEnvSource returns a Source reading a single environment variable.
+// An unset variable loads as empty.
+func SysEnvSource(key string) Source {
+	return &sysEnvSource{key: key}
+}
+
+type sysEnvSource struct {
+	key string
+}
+
+func (s *sysEnvSource) Name() string                { return "sysenv:" + s.key }
+func (s *sysEnvSource) origin() (kind, path string) { return "sysenv", s.key }
+
+func (s *sysEnvSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *sysEnvSource) load(_ context.Context, opts loadOptions) (loaded, error) {
+	tree := make(map[string]interface{})
+	path := splitKeyPath(s.key, opts.delimiter)
+	if val, ok := os.LookupEnv(s.key); ok {
+		setPath(tree, path, val)
+		return loaded{tree: tree}, nil
+	}
+	if _, ok := os.LookupEnv(s.key + envFileSuffix); ok && opts.envFiles {
+		val, err := readEnvFile(s.key + envFileSuffix)
+		if err != nil {
+			return loaded{}, err
+		}
+		setPath(tree, path, val)
+		return loaded{tree: tree, secrets: map[string]bool{pathKey(path): true}}, nil
+	}
+	return loaded{tree: tree}, nil
+}
+
+// SysEnvPrefixSource returns a Source reading every environment variable
+// starting with prefix, as described for LoadFromSysEnvPrefix.
+func SysEnvPrefixSource(prefix string) Source {
+	return &sysEnvPrefixSource{prefix: prefix}
+}
+
+type sysEnvPrefixSource struct {
+	prefix string
+}
+
+func (s *sysEnvPrefixSource) Name() string                { return "sysenv:" + s.prefix + "*" }
+func (s *sysEnvPrefixSource) origin() (kind, path string) { return "sysenv", s.prefix + "*" }
+
+func (s *sysEnvPrefixSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *sysEnvPrefixSource) load(_ context.Context, opts loadOptions) (loaded, error) {
+	tree := make(map[string]interface{})
+	secrets := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		name, val, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, s.prefix) || len(name) == len(s.prefix) {
+			continue
+		}
+		fromFile := false
+		if base, ok := strings.CutSuffix(name, envFileSuffix); ok && opts.envFiles && len(base) > len(s.prefix) {
+			if _, set := os.LookupEnv(base); set {
+				continue // FOO wins over FOO_FILE
+			}
+			var err error
+			if val, err = readEnvFile(name); err != nil {
+				return loaded{}, err
+			}
+			name, fromFile = base, true
+		}
+		var path []string
+		for _, seg := range strings.Split(strings.TrimPrefix(name, s.prefix), envNestingSeparator) {
+			if seg != "" {
+				path = append(path, normalizeKey(seg))
+			}
+		}
+		setPath(tree, path, val)
+		if fromFile {
+			secrets[pathKey(path)] = true
+		}
 	}
+	return loaded{tree: tree, secrets: secrets}, nil
 }
@@ -0,0 +1,341 @@
+package configmgr
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// EncryptionFormatVersion is the envelope version written by EncryptBytes.
+//
+// Version 2 files are base64 of:
+//
+//	magic "CMGR" | version (1 byte) | key id length (1 byte) | key id
+//	| KDF id (1 byte) | 3 KDF parameters (uint32, big endian)
+//	| salt length (1 byte) | salt | nonce length (1 byte) | nonce | AES-256-GCM ciphertext
+//
+// Everything before the ciphertext is authenticated as GCM additional data.
+// Version 1 is the same without the key id. Version 0 files (no header: nonce
+// and ciphertext, key = SHA-256 of the secret) are still read.
+const EncryptionFormatVersion = 2
+
+var envelopeMagic = []byte("CMGR")
+
+// ErrUnsupportedEncryptionVersion is returned for files written by a newer version of the library.
+var ErrUnsupportedEncryptionVersion = errors.New("unsupported encrypted file version")
+
+// KDF identifies the function deriving the AES key from the secret.
+type KDF byte
+
+const (
+	KDFArgon2id KDF = 1 // Argon2id (RFC 9106), the default
+	KDFScrypt   KDF = 2 // scrypt (RFC 7914)
+	KDFNone     KDF = 3 // none: the secret is a random data key (see KMSKey)
+)
+
+func (k KDF) String() string {
+	switch k {
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFScrypt:
+		return "scrypt"
+	case KDFNone:
+		return "none"
+	default:
+		return fmt.Sprintf("kdf(%d)", byte(k))
+	}
+}
+
+// EncryptOption configures EncryptBytes and EncryptFile.
+type EncryptOption func(*encryptConfig)
+
+type encryptConfig struct {
+	kdf   kdfParams
+	keyID string
+}
+
+func newEncryptConfig(opts []EncryptOption) encryptConfig {
+	c := encryptConfig{kdf: defaultKDF}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithArgon2id derives the key with Argon2id. This is the default, with
+// time=3, memory=64 MiB and threads=4. Time is limited to 10 and memory to 1 GiB.
+func WithArgon2id(time, memoryKiB uint32, threads uint8) EncryptOption {
+	return func(c *encryptConfig) {
+		c.kdf = kdfParams{kdf: KDFArgon2id, p1: time, p2: memoryKiB, p3: uint32(threads)}
+	}
+}
+
+// WithScrypt derives the key with scrypt; n must be a power of two, e.g. 1<<15.
+// The memory used, 128*n*r bytes, is limited to 1 GiB and p to 16.
+func WithScrypt(n, r, p int) EncryptOption {
+	return func(c *encryptConfig) {
+		c.kdf = kdfParams{kdf: KDFScrypt, p1: uint32(n), p2: uint32(r), p3: uint32(p)}
+	}
+}
+
+// WithKeyID records the id of the encryption key in the file header, so a
+// Keyring can pick the right key when decrypting.
+func WithKeyID(id string) EncryptOption {
+	return func(c *encryptConfig) { c.keyID = id }
+}
+
+// withDataKey uses the secret, a base64 data key, as the AES key without a KDF.
+func withDataKey() EncryptOption {
+	return func(c *encryptConfig) { c.kdf = kdfParams{kdf: KDFNone} }
+}
+
+// kdfParams holds a KDF and its parameters: time, memory (KiB) and threads
+// for Argon2id; N, r and p for scrypt.
+type kdfParams struct {
+	kdf        KDF
+	p1, p2, p3 uint32
+}
+
+var defaultKDF = kdfParams{kdf: KDFArgon2id, p1: 3, p2: 64 * 1024, p3: 4}
+
+const (
+	keySize  = 32
+	saltSize = 16
+)
+
+// Limits of the KDF parameters accepted, so a crafted header cannot make a
+// load allocate gigabytes or burn minutes of CPU.
+const (
+	maxKDFMemoryKiB = 1 << 20 // 1 GiB
+	maxArgon2Time   = 10
+	maxScryptP      = 16
+)
+
+// check rejects unknown KDFs and parameters too weak or too expensive to run;
+// the parameters of a file come from its (not yet authenticated) header.
+func (p kdfParams) check() error {
+	switch p.kdf {
+	case KDFArgon2id:
+		if p.p1 < 1 || p.p1 > maxArgon2Time || p.p3 < 1 || p.p3 > 255 || p.p2 < 8*p.p3 || p.p2 > maxKDFMemoryKiB {
+			return fmt.Errorf("invalid argon2id parameters t=%d m=%d p=%d", p.p1, p.p2, p.p3)
+		}
+	case KDFScrypt:
+		// scrypt uses 128*N*r bytes
+		if p.p1 < 2 || p.p1&(p.p1-1) != 0 || p.p2 < 1 || p.p3 < 1 || p.p3 > maxScryptP ||
+			uint64(p.p1)*uint64(p.p2)*128 > maxKDFMemoryKiB*1024 {
+			return fmt.Errorf("invalid scrypt parameters N=%d r=%d p=%d", p.p1, p.p2, p.p3)
+		}
+	case KDFNone:
+		if p.p1 != 0 || p.p2 != 0 || p.p3 != 0 {
+			return fmt.Errorf("invalid parameters %d %d %d for no KDF", p.p1, p.p2, p.p3)
+		}
+	default:
+		return fmt.Errorf("unknown key derivation function %s", p.kdf)
+	}
+	return nil
+}
+
+func (p kdfParams) deriveKey(secret string, salt []byte) ([]byte, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	switch p.kdf {
+	case KDFScrypt:
+		return scrypt.Key([]byte(secret), salt, int(p.p1), int(p.p2), int(p.p3), keySize)
+	case KDFNone:
+		key, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("secret is not a base64 %d-byte data key", keySize)
+		}
+		return key, nil
+	}
+	return argon2.IDKey([]byte(secret), salt, p.p1, p.p2, uint8(p.p3), keySize), nil
+}
+
+// envelope is the header of an encrypted file.
+type envelope struct {
+	version byte
+	keyID   string
+	kdf     kdfParams
+	salt    []byte
+	nonce   []byte
+}
+
+func (e envelope) marshal() []byte {
+	var b bytes.Buffer
+	b.Write(envelopeMagic)
+	b.WriteByte(e.version)
+	b.WriteByte(byte(len(e.keyID)))
+	b.WriteString(e.keyID)
+	b.WriteByte(byte(e.kdf.kdf))
+	for _, v := range []uint32{e.kdf.p1, e.kdf.p2, e.kdf.p3} {
+		_ = binary.Write(&b, binary.BigEndian, v)
+	}
+	b.WriteByte(byte(len(e.salt)))
+	b.Write(e.salt)
+	b.WriteByte(byte(len(e.nonce)))
+	b.Write(e.nonce)
+	return b.Bytes()
+}
+
+// parseEnvelope splits raw into its header and ciphertext.
+// header is the raw header bytes, used as additional data.
+func parseEnvelope(raw []byte) (e envelope, header, ciphertext []byte, err error) {
+	errShort := errors.New("encrypted file header is truncated")
+	r := raw[len(envelopeMagic):]
+	if len(r) < 1 {
+		return e, nil, nil, errShort
+	}
+	e.version, r = r[0], r[1:]
+	if e.version < 1 || e.version > EncryptionFormatVersion {
+		return e, nil, nil, fmt.Errorf("%w %d", ErrUnsupportedEncryptionVersion, e.version)
+	}
+
+	var ok bool
+	if e.version >= 2 {
+		var id []byte
+		if id, r, ok = cutField(r); !ok {
+			return e, nil, nil, errShort
+		}
+		e.keyID = string(id)
+	}
+	if len(r) < 1+12 {
+		return e, nil, nil, errShort
+	}
+	e.kdf.kdf = KDF(r[0])
+	e.kdf.p1 = binary.BigEndian.Uint32(r[1:])
+	e.kdf.p2 = binary.BigEndian.Uint32(r[5:])
+	e.kdf.p3 = binary.BigEndian.Uint32(r[9:])
+	r = r[13:]
+
+	if e.salt, r, ok = cutField(r); !ok {
+		return e, nil, nil, errShort
+	}
+	if e.nonce, r, ok = cutField(r); !ok {
+		return e, nil, nil, errShort
+	}
+	n := len(raw) - len(r)
+	return e, raw[:n], raw[n:], nil
+}
+
+// cutField reads a length-prefixed field.
+func cutField(b []byte) (field, rest []byte, ok bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, nil, false
+	}
+	n := int(b[0])
+	return b[1 : 1+n], b[1+n:], true
+}
+
+// seal encrypts plaintext into a current-version envelope.
+func seal(plaintext []byte, secret string, c encryptConfig) ([]byte, error) {
+	if len(c.keyID) > 255 {
+		return nil, fmt.Errorf("key id %q is longer than 255 bytes", c.keyID)
+	}
+	e := envelope{version: EncryptionFormatVersion, keyID: c.keyID, kdf: c.kdf, salt: make([]byte, saltSize)}
+	if _, err := rand.Read(e.salt); err != nil {
+		return nil, err
+	}
+	key, err := e.kdf.deriveKey(secret, e.salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	e.nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(e.nonce); err != nil {
+		return nil, err
+	}
+	header := e.marshal()
+	return gcm.Seal(header, e.nonce, plaintext, header), nil
+}
+
+// open decrypts an envelope, or a version 0 file when raw has no header.
+func open(raw []byte, secret string) ([]byte, error) {
+	if !bytes.HasPrefix(raw, envelopeMagic) {
+		return openV0(raw, secret)
+	}
+	plaintext, err := openEnvelope(raw, secret)
+	if err != nil {
+		// a version 0 nonce may start with the magic bytes by chance
+		if p, errV0 := openV0(raw, secret); errV0 == nil {
+			return p, nil
+		}
+	}
+	return plaintext, err
+}
+
+func openEnvelope(raw []byte, secret string) ([]byte, error) {
+	e, header, ciphertext, err := parseEnvelope(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.kdf.deriveKey(secret, e.salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size %d", len(e.nonce))
+	}
+	plaintext, err := gcm.Open(nil, e.nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+	return plaintext, nil
+}
+
+// openV0 decrypts the original headerless format.
+func openV0(raw []byte, secret string) ([]byte, error) {
+	key := sha256.Sum256([]byte(secret))
+	gcm, err := newGCM(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := raw[:gcm.NonceSize()], raw[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// parseHeader returns the header of raw (decoded) file contents; version 0
+// files have an empty one.
+func parseHeader(raw []byte) envelope {
+	if !bytes.HasPrefix(raw, envelopeMagic) {
+		return envelope{}
+	}
+	e, _, _, err := parseEnvelope(raw)
+	if err != nil {
+		return envelope{}
+	}
+	return e
+}
@@ -0,0 +1,333 @@
+package configmgr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// redactedValue replaces secret values in output, see Secret.
+const redactedValue = "[REDACTED]"
+
+// FieldError describes one problem with one config value.
+type FieldError struct {
+	Field   string      `json:"field,omitempty"`  // struct field path, e.g. "Database.Pool.Max"
+	Key     string      `json:"key,omitempty"`    // config key, e.g. "DATABASE.POOL.MAX"
+	Source  string      `json:"source,omitempty"` // kind of the source that set the key: "file", "env", ...
+	Path    string      `json:"path,omitempty"`   // file path, or the variable name for environment sources
+	Line    int         `json:"line,omitempty"`   // 1-based line in Path, 0 when unknown
+	Value   interface{} `json:"value,omitempty"`  // offending value, redacted for secrets
+	Rule    string      `json:"rule"`             // "decode", "default", "unused", the failed validate tag or schema keyword
+	Message string      `json:"message"`
+	Err     error       `json:"-"` // underlying error, if any
+}
+
+func (e FieldError) Error() string {
+	name := e.Field
+	if name == "" {
+		name = e.Key
+	}
+	if name == "" {
+		return e.Message
+	}
+	return name + ": " + e.Message
+}
+
+func (e FieldError) Unwrap() error { return e.Err }
+
+// location renders the key, value and source of e for Pretty.
+func (e FieldError) location() string {
+	var parts []string
+	if e.Key != "" {
+		parts = append(parts, "key "+e.Key)
+	}
+	if e.Value != nil {
+		parts = append(parts, fmt.Sprintf("value %v", e.Value))
+	}
+	if src := (Origin{Kind: e.Source, Path: e.Path, Line: e.Line}).String(); e.Source != "" {
+		parts = append(parts, "from "+src)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ConfigError collects every problem Unmarshal found: values that cannot be
+// decoded, invalid defaults, failed validations and, with Strict, unused keys.
+// Use errors.As to get it from the error returned by Unmarshal.
+type ConfigError struct {
+	Errors []FieldError
+
+	validation validator.ValidationErrors // as returned by the validator, see Unwrap
+}
+
+func (e *ConfigError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return fmt.Sprintf("%d config errors: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the underlying errors, so errors.Is and errors.As see them,
+// including the validator.ValidationErrors of failed validations.
+func (e *ConfigError) Unwrap() []error {
+	var errs []error
+	if len(e.validation) > 0 {
+		errs = append(errs, e.validation)
+	}
+	for _, fe := range e.Errors {
+		if fe.Err != nil {
+			errs = append(errs, fe.Err)
+		}
+	}
+	return errs
+}
+
+// Pretty renders the errors for operators, one per entry with its key,
+// value and source:
+//
+//	config has 2 errors:
+//	  Database.Pool.Max: must be at most 100 [max]
+//	      key DATABASE.POOL.MAX, value 500, from file config.yaml:12
+//	  APP_PROT: key is not used by any field [unused]
+//	      key APP_PROT, value 9090, from file config.yaml:2
+func (e *ConfigError) Pretty() string {
+	var b strings.Builder
+	noun := "errors"
+	if len(e.Errors) == 1 {
+		noun = "error"
+	}
+	fmt.Fprintf(&b, "config has %d %s:\n", len(e.Errors), noun)
+	for _, fe := range e.Errors {
+		fmt.Fprintf(&b, "  %s [%s]\n", fe.Error(), fe.Rule)
+		if loc := fe.location(); loc != "" {
+			fmt.Fprintf(&b, "      %s\n", loc)
+		}
+	}
+	return b.String()
+}
+
+// pathError is an error decoding, or applying the default of, the field at path.
+type pathError struct {
+	path  string
+	value interface{}
+	rule  string
+	err   error
+}
+
+func (e *pathError) Error() string { return e.path + ": " + e.err.Error() }
+func (e *pathError) Unwrap() error { return e.err }
+
+// decodeError attaches a field path and the raw value to err, unless a
+// nested field already did.
+func decodeError(path string, value interface{}, err error) error {
+	var pe *pathError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return &pathError{path: path, value: value, rule: "decode", err: err}
+}
+
+// configError turns the errors of an Unmarshal into a *ConfigError, looking
+// up the config key and source of every field of struct type t.
+func (cm *ConfigManager) configError(t reflect.Type, errs []error) *ConfigError {
+	index := make(fieldIndex)
+	index.add(t, []string{""}, nil, map[reflect.Type]bool{})
+
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	out := &ConfigError{}
+	failed := make(map[string]bool) // fields that did not decode
+	for _, err := range errs {
+		var pe *pathError
+		var unused *UnusedKeysError
+		var fe validator.FieldError
+		switch {
+		case errors.As(err, &pe):
+			failed[pe.path] = true
+			out.Errors = append(out.Errors, cm.fieldError(index, pe.path, pe.value, pe.rule, pe.err.Error(), pe.err))
+		case errors.As(err, &fe):
+			field := fe.StructNamespace()
+			if _, rest, ok := strings.Cut(field, "."); ok {
+				field = rest // drop the root type name
+			}
+			if failed[field] {
+				continue
+			}
+			out.Errors = append(out.Errors, cm.fieldError(index, field, fe.Value(), fe.Tag(), validationMessage(fe), fe))
+		case errors.As(err, &unused):
+			for _, p := range unused.Keys {
+				value := p.Origin.Value
+				if _, ok := value.(Secret); ok {
+					value = redactedValue
+				}
+				out.Errors = append(out.Errors, FieldError{
+					Key: p.Key, Source: p.Origin.Kind, Path: p.Origin.Path, Line: p.Origin.Line,
+					Value: value, Rule: "unused", Message: "key is not used by any field", Err: unused,
+				})
+			}
+		default:
+			out.Errors = append(out.Errors, FieldError{Rule: "error", Message: err.Error(), Err: err})
+		}
+	}
+	return out
+}
+
+// fieldError builds the FieldError of the struct field at path. The caller must hold cm.mu.
+func (cm *ConfigManager) fieldError(index fieldIndex, path string, value interface{}, rule, msg string, err error) FieldError {
+	fe := FieldError{Field: path, Value: value, Rule: rule, Message: msg, Err: err}
+	key, secret, ok := index.key(path)
+	if !ok {
+		return fe
+	}
+	fe.Key = cm.displayKey(pathKey(key))
+	if !cm.locate(&fe, key) && value != nil && reflect.ValueOf(value).IsZero() {
+		value = nil // the key is not set
+	}
+	fe.Value = value
+	if value != nil && (secret || cm.isSecret(key)) {
+		fe.Value = redactedValue
+		// decode messages quote the value (see conversionError)
+		if s := fmt.Sprint(value); s != "" {
+			fe.Message = strings.ReplaceAll(fe.Message, s, redactedValue)
+		}
+	}
+	return fe
+}
+
+// locate sets the source of fe to where the value at path was set and
+// reports whether it is known. The caller must hold cm.mu.
+func (cm *ConfigManager) locate(fe *FieldError, path []string) bool {
+	for n := len(path); n > 0; n-- {
+		// list elements are recorded with their list
+		if p, ok := cm.explain(path[:n]); ok {
+			fe.Source, fe.Path, fe.Line = p.Origin.Kind, p.Origin.Path, p.Origin.Line
+			return true
+		}
+	}
+	return false
+}
+
+// fieldInfo is the config key of a struct field.
+type fieldInfo struct {
+	key    []string
+	secret bool // tagged `secret:"true"`
+}
+
+// fieldIndex maps struct field paths (Go names, without indices) to config keys.
+type fieldIndex map[string]fieldInfo
+
+// add indexes the fields of struct type t. prefixes are the field paths of t:
+// the fields of embedded structs are reachable with and without the embedded name.
+func (idx fieldIndex) add(t reflect.Type, prefixes []string, key []string, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for _, fp := range planFor(t) {
+		sf := t.Field(fp.index)
+		paths := make([]string, len(prefixes))
+		for i, p := range prefixes {
+			paths[i] = joinFieldPath(p, fp.name)
+		}
+		if fp.squash {
+			idx.add(sf.Type, append(append([]string(nil), prefixes...), paths...), key, seen)
+			continue
+		}
+		k := appendPath(key, fp.key)
+		for _, p := range paths {
+			idx[p] = fieldInfo{key: k, secret: sf.Tag.Get("secret") == "true"}
+		}
+		et := sf.Type
+		for et.Kind() == reflect.Ptr || et.Kind() == reflect.Slice || et.Kind() == reflect.Array || et.Kind() == reflect.Map {
+			et = et.Elem()
+		}
+		if decodesAsStruct(et) {
+			idx.add(et, paths, k, seen)
+		}
+	}
+}
+
+// key returns the config key of a field path such as "Servers[1].Port" and
+// whether the field, or a field containing it, is secret.
+func (idx fieldIndex) key(path string) ([]string, bool, bool) {
+	var tmpl string
+	var key []string
+	var secret bool
+	consumed := 0 // segments of the index keys already in key
+	for _, seg := range splitFieldPath(path) {
+		name, indices, _ := strings.Cut(seg, "[")
+		tmpl = joinFieldPath(tmpl, name)
+		info, ok := idx[tmpl]
+		if !ok {
+			return nil, false, false
+		}
+		key = append(key, info.key[consumed:]...)
+		consumed = len(info.key)
+		secret = secret || info.secret
+		if indices != "" {
+			for _, i := range strings.Split(strings.TrimSuffix(indices, "]"), "][") {
+				key = append(key, normalizeKey(i))
+			}
+		}
+	}
+	return key, secret, len(key) > 0
+}
+
+// splitFieldPath splits a field path on dots outside of brackets.
+func splitFieldPath(path string) []string {
+	var segs []string
+	depth, start := 0, 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '.':
+			if depth == 0 {
+				segs = append(segs, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(segs, path[start:])
+}
+
+// validationMessage describes a failed validate tag in words.
+func validationMessage(fe validator.FieldError) string {
+	p := fe.Param()
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min", "gte":
+		return "must be at least " + p
+	case "max", "lte":
+		return "must be at most " + p
+	case "gt":
+		return "must be greater than " + p
+	case "lt":
+		return "must be less than " + p
+	case "len":
+		return "must have length " + p
+	case "oneof":
+		return "must be one of [" + p + "]"
+	case "email", "url", "uri", "hostname", "ip", "ipv4", "ipv6", "cidr":
+		return "must be a valid " + fe.Tag()
+	}
+	if p != "" {
+		return fmt.Sprintf("failed the %s=%s rule", fe.Tag(), p)
+	}
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
+}
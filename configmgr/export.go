@@ -1,8 +1,10 @@
 package configmgr
 
 import (
+	"bytes"
 	"encoding/json"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
 )
 
@@ -15,3 +17,12 @@ func (cm *ConfigManager) ToJSON() ([]byte, error) {
 func (cm *ConfigManager) ToYAML() ([]byte, error) {
 	return yaml.Marshal(cm.GetAll())
 }
+
+// ToTOML returns config as TOML.
+func (cm *ConfigManager) ToTOML() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(cm.GetAll()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
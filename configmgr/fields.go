@@ -0,0 +1,847 @@
+package configmgr
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Field-level encryption keeps the keys and structure of a YAML or JSON file
+// readable and only encrypts selected values:
+//
+//	database:
+//	  host: db.internal
+//	  password: ENC[AES256_GCM,data:Tr7o...,iv:1lr2...,tag:kXZL...,type:str]
+//	configmgr:
+//	  version: 1
+//	  key_id: "2025-06"
+//	  kdf: argon2id
+//	  kdf_params: [3, 65536, 4]
+//	  salt: 9J0x...
+//	  encrypted_regex: ^(password|token)$
+//	  mac: 5f1c...
+//
+// The "configmgr" block holds the metadata. A data key is derived from a
+// keyring key, the KDF and the salt; every value is encrypted with AES-256-GCM
+// and its key path as additional data, so values cannot be moved between keys.
+// mac is an HMAC-SHA256 over the whole decrypted document (keys and all
+// values, canonical JSON): editing, adding or removing any key or value,
+// plaintext or encrypted, makes loading fail with ErrMACMismatch.
+//
+// With a keyring configured, a document with ENC[...] values but no metadata
+// block fails too. A document whose block was removed and whose encrypted
+// values were all replaced with plaintext cannot be told apart from a
+// plaintext file and loads as one.
+
+// FieldMetadataKey is the top-level key holding the metadata of a document
+// with encrypted values.
+const FieldMetadataKey = "configmgr"
+
+// fieldMetadataOf returns the metadata block of a decoded document: the value
+// of FieldMetadataKey when it has the version and mac EncryptFields writes.
+// Any other value under that key is ordinary config.
+func fieldMetadataOf(tree map[string]interface{}) (interface{}, bool) {
+	m, ok := tree[FieldMetadataKey].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	_, version := m["version"]
+	_, mac := m["mac"]
+	return m, version && mac
+}
+
+// isYAMLFieldMetadata is fieldMetadataOf for the value node of FieldMetadataKey.
+func isYAMLFieldMetadata(n *yaml.Node) bool {
+	var m map[string]interface{}
+	if n.Kind != yaml.MappingNode || n.Decode(&m) != nil {
+		return false
+	}
+	_, ok := fieldMetadataOf(map[string]interface{}{FieldMetadataKey: m})
+	return ok
+}
+
+// errReservedMetadataKey is returned when encrypting a document that uses
+// FieldMetadataKey for its own config.
+var errReservedMetadataKey = fmt.Errorf("the top-level %s key is reserved for the encryption metadata", FieldMetadataKey)
+
+// fieldFormatVersion is the version of the metadata block.
+const fieldFormatVersion = 1
+
+// ErrMACMismatch is returned when a document with encrypted values was
+// modified after it was encrypted.
+var ErrMACMismatch = errors.New("MAC mismatch: the document was modified after encryption")
+
+// errNoFieldMetadata is returned for a document with encrypted values whose
+// metadata block was removed.
+var errNoFieldMetadata = fmt.Errorf("%w: encrypted values without a %s metadata block", ErrMACMismatch, FieldMetadataKey)
+
+var encValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:([A-Za-z0-9+/=]*),iv:([A-Za-z0-9+/=]+),tag:([A-Za-z0-9+/=]+),type:(str|int|float|bool|time)\]$`)
+
+// fieldMetadata is the metadata block of a document with encrypted values.
+type fieldMetadata struct {
+	Version        int      `json:"version" yaml:"version"`
+	KeyID          string   `json:"key_id,omitempty" yaml:"key_id,omitempty"`
+	KDF            string   `json:"kdf" yaml:"kdf"`
+	KDFParams      []uint32 `json:"kdf_params" yaml:"kdf_params,flow"`
+	Salt           string   `json:"salt" yaml:"salt"`
+	EncryptedRegex string   `json:"encrypted_regex,omitempty" yaml:"encrypted_regex,omitempty"`
+	MAC            string   `json:"mac" yaml:"mac"`
+}
+
+// SetKeyring sets the keyring used to decrypt encrypted values in files
+// loaded afterwards (and on Reload).
+func (cm *ConfigManager) SetKeyring(kr *Keyring) {
+	if kr == nil {
+		cm.SetKeyProvider(nil)
+		return
+	}
+	cm.SetKeyProvider(kr)
+}
+
+// SetKeyProvider is like SetKeyring, asking p for the keys on every load.
+func (cm *ConfigManager) SetKeyProvider(p KeyProvider) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.keys = p
+}
+
+// fieldCipher encrypts values and computes the MAC of one document.
+type fieldCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+func newFieldCipher(secret string, p kdfParams, salt []byte) (*fieldCipher, error) {
+	master, err := p.deriveKey(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	encKey, err := hkdf.Key(sha256.New, master, nil, "configmgr field encryption", keySize)
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := hkdf.Key(sha256.New, master, nil, "configmgr field mac", keySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(encKey)
+	if err != nil {
+		return nil, err
+	}
+	return &fieldCipher{aead: aead, macKey: macKey}, nil
+}
+
+// encryptValue encrypts a scalar, remembering its type.
+func (c *fieldCipher) encryptValue(v interface{}, path []string) (string, error) {
+	var typ, s string
+	switch t := v.(type) {
+	case string:
+		typ, s = "str", t
+	case bool:
+		typ, s = "bool", strconv.FormatBool(t)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		typ, s = "int", fmt.Sprint(t)
+	case float32:
+		typ, s = "float", strconv.FormatFloat(float64(t), 'g', -1, 32)
+	case float64:
+		typ, s = "float", strconv.FormatFloat(t, 'g', -1, 64)
+	case time.Time:
+		typ, s = "time", t.Format(time.RFC3339Nano)
+	default:
+		return "", fmt.Errorf("%s: cannot encrypt a value of type %T", strings.Join(path, "."), v)
+	}
+
+	iv := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nil, iv, []byte(s), fieldAAD(path))
+	data, tag := sealed[:len(sealed)-c.aead.Overhead()], sealed[len(sealed)-c.aead.Overhead():]
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), typ), nil
+}
+
+// decryptValue decrypts an ENC[...] string into a value of its original type.
+func (c *fieldCipher) decryptValue(s string, path []string) (interface{}, error) {
+	m := encValuePattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%s: malformed encrypted value", strings.Join(path, "."))
+	}
+	var parts [3][]byte
+	for i := range parts {
+		b, err := base64.StdEncoding.DecodeString(m[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: malformed encrypted value: %w", strings.Join(path, "."), err)
+		}
+		parts[i] = b
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	if len(iv) != c.aead.NonceSize() {
+		return nil, fmt.Errorf("%s: invalid iv size %d", strings.Join(path, "."), len(iv))
+	}
+	plain, err := c.aead.Open(nil, iv, append(data, tag...), fieldAAD(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: decryption failed: %w", strings.Join(path, "."), err)
+	}
+
+	str := string(plain)
+	switch m[4] {
+	case "bool":
+		return strconv.ParseBool(str)
+	case "int":
+		i, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return int(i), nil
+	case "float":
+		return strconv.ParseFloat(str, 64)
+	case "time":
+		return time.Parse(time.RFC3339Nano, str)
+	default:
+		return str, nil
+	}
+}
+
+// fieldAAD binds a value to its key path.
+func fieldAAD(path []string) []byte {
+	return []byte(strings.Join(path, ":") + ":")
+}
+
+// mac computes the MAC of a decrypted document without its metadata block.
+func (c *fieldCipher) mac(tree map[string]interface{}) (string, error) {
+	b, err := json.Marshal(canonicalTree(tree))
+	if err != nil {
+		return "", fmt.Errorf("cannot compute MAC: %w", err)
+	}
+	h := hmac.New(sha256.New, c.macKey)
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canonicalTree converts a decoded document into a JSON-encodable value;
+// encoding/json sorts map keys, which makes the encoding canonical.
+func canonicalTree(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			out[k] = canonicalTree(e)
+		}
+		return out
+	case map[interface{}]interface{}:
+		return canonicalTree(stringKeyMap(t))
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			out[i] = canonicalTree(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func isEncryptedValue(v interface{}) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, "ENC[")
+}
+
+// hasEncryptedValues reports whether a decoded document holds an ENC[...] value.
+func hasEncryptedValues(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, e := range t {
+			if hasEncryptedValues(e) {
+				return true
+			}
+		}
+	case map[interface{}]interface{}:
+		for _, e := range t {
+			if hasEncryptedValues(e) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, e := range t {
+			if hasEncryptedValues(e) {
+				return true
+			}
+		}
+	default:
+		return isEncryptedValue(v)
+	}
+	return false
+}
+
+// parseFieldMetadata reads the metadata block of a decoded document.
+func parseFieldMetadata(v interface{}) (fieldMetadata, kdfParams, []byte, error) {
+	var meta fieldMetadata
+	b, err := json.Marshal(canonicalTree(v))
+	if err == nil {
+		err = json.Unmarshal(b, &meta)
+	}
+	if err != nil {
+		return meta, kdfParams{}, nil, fmt.Errorf("invalid %s metadata: %w", FieldMetadataKey, err)
+	}
+	if meta.Version != fieldFormatVersion {
+		return meta, kdfParams{}, nil, fmt.Errorf("unsupported %s metadata version %d", FieldMetadataKey, meta.Version)
+	}
+	p := kdfParams{kdf: kdfByName(meta.KDF)}
+	if len(meta.KDFParams) != 3 {
+		return meta, kdfParams{}, nil, fmt.Errorf("invalid %s metadata: kdf_params needs 3 values", FieldMetadataKey)
+	}
+	p.p1, p.p2, p.p3 = meta.KDFParams[0], meta.KDFParams[1], meta.KDFParams[2]
+	if err := p.check(); err != nil {
+		return meta, kdfParams{}, nil, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(meta.Salt)
+	if err != nil {
+		return meta, kdfParams{}, nil, fmt.Errorf("invalid %s metadata salt: %w", FieldMetadataKey, err)
+	}
+	return meta, p, salt, nil
+}
+
+func kdfByName(name string) KDF {
+	for _, k := range []KDF{KDFArgon2id, KDFScrypt, KDFNone} {
+		if k.String() == name {
+			return k
+		}
+	}
+	return 0
+}
+
+// openDocument finds the keyring key that decrypts a document and matches its
+// MAC: the key named by the metadata first, then the others. decrypt decrypts
+// the document with a candidate cipher and returns its plaintext tree.
+// ErrMACMismatch is returned when a key decrypts the values but no key matches.
+func openDocument(kr *Keyring, meta fieldMetadata, p kdfParams, salt []byte,
+	decrypt func(*fieldCipher) (map[string]interface{}, error)) (*fieldCipher, error) {
+	if kr == nil {
+		return nil, fmt.Errorf("document has encrypted values but no keyring is configured (SetKeyring, SetKeyProvider)")
+	}
+	if len(kr.keys) == 0 {
+		return nil, ErrEmptyKeyring
+	}
+	var lastErr, macErr error
+	for _, k := range kr.candidates(meta.KeyID) {
+		c, err := newFieldCipher(k.Secret, p, salt)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := decrypt(c)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		mac, err := c.mac(plain)
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal([]byte(mac), []byte(meta.MAC)) {
+			// a wrong key decrypts a document without encrypted values
+			macErr = ErrMACMismatch
+			continue
+		}
+		return c, nil
+	}
+	if macErr != nil {
+		return nil, macErr
+	}
+	return nil, lastErr
+}
+
+// decryptFieldTree decrypts a decoded document with encrypted values in place
+// and removes its metadata block. Documents without one are returned as is,
+// unless kr is set and they have encrypted values.
+func decryptFieldTree(tree map[string]interface{}, kr *Keyring) (map[string]interface{}, error) {
+	rawMeta, ok := fieldMetadataOf(tree)
+	if !ok {
+		if kr != nil && hasEncryptedValues(tree) {
+			return nil, errNoFieldMetadata
+		}
+		return tree, nil
+	}
+	meta, p, salt, err := parseFieldMetadata(rawMeta)
+	if err != nil {
+		return nil, err
+	}
+	delete(tree, FieldMetadataKey)
+
+	var plain map[string]interface{}
+	_, err = openDocument(kr, meta, p, salt, func(c *fieldCipher) (map[string]interface{}, error) {
+		v, err := decryptTreeValues(c, tree, nil)
+		if err != nil {
+			return nil, err
+		}
+		plain = v.(map[string]interface{})
+		return plain, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// decryptYAMLFields decrypts a YAML document with encrypted values into a
+// tree. It decrypts the document nodes rather than the decoded tree, so
+// values merged in through aliases are decrypted with the path of their anchor.
+func decryptYAMLFields(raw []byte, kr *Keyring) (map[string]interface{}, error) {
+	doc, _, err := openYAMLDocument(raw, kr)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(map[string]interface{})
+	if err := doc.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// decryptTreeValues returns a copy of v with every ENC[...] value decrypted.
+func decryptTreeValues(c *fieldCipher, v interface{}, path []string) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			d, err := decryptTreeValues(c, e, appendPath(path, k))
+			if err != nil {
+				return nil, err
+			}
+			out[k] = d
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			d, err := decryptTreeValues(c, e, appendPath(path, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = d
+		}
+		return out, nil
+	default:
+		if isEncryptedValue(v) {
+			return c.decryptValue(v.(string), path)
+		}
+		return v, nil
+	}
+}
+
+// EncryptFields encrypts the values of a YAML or JSON document (format "yaml",
+// "yml" or "json") whose key matches keyRegex, with the primary key of kr.
+// Values below a matching key are all encrypted; keys and other values stay
+// plaintext. YAML comments and key order are kept; JSON is re-indented.
+//
+// A document that already has encrypted values is decrypted with kr first,
+// so running EncryptFields again re-encrypts it under the primary key, e.g.
+// after a key rotation. An empty keyRegex reuses the regex stored in the document.
+func EncryptFields(raw []byte, format string, kr *Keyring, keyRegex string, opts ...EncryptOption) ([]byte, error) {
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "yaml", "yml":
+		return encryptYAMLFields(raw, kr, keyRegex, opts)
+	case "json":
+		return encryptJSONFields(raw, kr, keyRegex, opts)
+	default:
+		return nil, fmt.Errorf("field encryption does not support %s files", format)
+	}
+}
+
+// EncryptFieldsFile encrypts the values of the file src whose key matches
+// keyRegex into dst, which may be src. The format follows the extension of src.
+// dst is replaced atomically; an existing dst keeps its permissions, a new
+// one is readable only by its owner.
+func EncryptFieldsFile(src, dst string, kr *Keyring, keyRegex string, opts ...EncryptOption) error {
+	raw, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	data, err := EncryptFields(raw, filepath.Ext(src), kr, keyRegex, opts...)
+	if err != nil {
+		return err
+	}
+	return rewriteFile(dst, data)
+}
+
+// DecryptFields returns a YAML or JSON document with its encrypted values
+// decrypted and its metadata block removed, after checking its MAC.
+func DecryptFields(raw []byte, format string, kr *Keyring) ([]byte, error) {
+	switch strings.TrimPrefix(strings.ToLower(format), ".") {
+	case "yaml", "yml":
+		doc, _, err := openYAMLDocument(raw, kr)
+		if err != nil {
+			return nil, err
+		}
+		return marshalYAMLNode(doc)
+	case "json":
+		tree := make(map[string]interface{})
+		if err := json.Unmarshal(raw, &tree); err != nil {
+			return nil, err
+		}
+		plain, err := decryptFieldTree(tree, kr)
+		if err != nil {
+			return nil, err
+		}
+		return marshalJSONDocument(plain)
+	default:
+		return nil, fmt.Errorf("field encryption does not support %s files", format)
+	}
+}
+
+// DecryptFieldsFile decrypts the values of the file src into dst, see
+// DecryptFields. dst is replaced atomically and readable only by its owner.
+func DecryptFieldsFile(src, dst string, kr *Keyring) error {
+	raw, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	plaintext, err := DecryptFields(raw, filepath.Ext(src), kr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	return writeFileAtomic(dst, plaintext, 0600)
+}
+
+// newFieldMetadata creates the metadata and cipher for encrypting a document.
+func newFieldMetadata(kr *Keyring, keyRegex string, opts []EncryptOption) (fieldMetadata, *fieldCipher, error) {
+	if kr == nil {
+		return fieldMetadata{}, nil, fmt.Errorf("field encryption needs a keyring")
+	}
+	primary, err := kr.Primary()
+	if err != nil {
+		return fieldMetadata{}, nil, err
+	}
+	c := newEncryptConfig(primary.encryptOptions(opts))
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fieldMetadata{}, nil, err
+	}
+	fc, err := newFieldCipher(primary.Secret, c.kdf, salt)
+	if err != nil {
+		return fieldMetadata{}, nil, err
+	}
+	meta := fieldMetadata{
+		Version:        fieldFormatVersion,
+		KeyID:          primary.ID,
+		KDF:            c.kdf.kdf.String(),
+		KDFParams:      []uint32{c.kdf.p1, c.kdf.p2, c.kdf.p3},
+		Salt:           base64.StdEncoding.EncodeToString(salt),
+		EncryptedRegex: keyRegex,
+	}
+	return meta, fc, nil
+}
+
+func compileKeyRegex(keyRegex, stored string) (string, *regexp.Regexp, error) {
+	if keyRegex == "" {
+		keyRegex = stored
+	}
+	if keyRegex == "" {
+		return "", nil, fmt.Errorf("no key regex given")
+	}
+	re, err := regexp.Compile(keyRegex)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid key regex: %w", err)
+	}
+	return keyRegex, re, nil
+}
+
+func encryptJSONFields(raw []byte, kr *Keyring, keyRegex string, opts []EncryptOption) ([]byte, error) {
+	tree := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		return nil, err
+	}
+	var stored string
+	if rawMeta, ok := fieldMetadataOf(tree); ok {
+		meta, _, _, err := parseFieldMetadata(rawMeta)
+		if err != nil {
+			return nil, err
+		}
+		stored = meta.EncryptedRegex
+		if tree, err = decryptFieldTree(tree, kr); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := tree[FieldMetadataKey]; ok {
+		return nil, errReservedMetadataKey
+	}
+	keyRegex, re, err := compileKeyRegex(keyRegex, stored)
+	if err != nil {
+		return nil, err
+	}
+	meta, c, err := newFieldMetadata(kr, keyRegex, opts)
+	if err != nil {
+		return nil, err
+	}
+	if meta.MAC, err = c.mac(tree); err != nil {
+		return nil, err
+	}
+
+	enc, err := encryptTreeValues(c, tree, nil, false, re)
+	if err != nil {
+		return nil, err
+	}
+	out := enc.(map[string]interface{})
+	out[FieldMetadataKey] = meta
+	return marshalJSONDocument(out)
+}
+
+// encryptTreeValues returns a copy of v with the values below matching keys encrypted.
+func encryptTreeValues(c *fieldCipher, v interface{}, path []string, match bool, re *regexp.Regexp) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			d, err := encryptTreeValues(c, e, appendPath(path, k), match || re.MatchString(k), re)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = d
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			d, err := encryptTreeValues(c, e, appendPath(path, strconv.Itoa(i)), match, re)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = d
+		}
+		return out, nil
+	case nil:
+		return nil, nil
+	default:
+		if !match {
+			return v, nil
+		}
+		return c.encryptValue(v, path)
+	}
+}
+
+func marshalJSONDocument(v interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func encryptYAMLFields(raw []byte, kr *Keyring, keyRegex string, opts []EncryptOption) ([]byte, error) {
+	doc, stored, err := openYAMLDocument(raw, kr)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(doc.Content[0].Content); i += 2 {
+		if doc.Content[0].Content[i].Value == FieldMetadataKey {
+			return nil, errReservedMetadataKey
+		}
+	}
+	keyRegex, re, err := compileKeyRegex(keyRegex, stored)
+	if err != nil {
+		return nil, err
+	}
+	meta, c, err := newFieldMetadata(kr, keyRegex, opts)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(map[string]interface{})
+	if err := doc.Decode(&tree); err != nil {
+		return nil, err
+	}
+	if meta.MAC, err = c.mac(tree); err != nil {
+		return nil, err
+	}
+
+	err = walkYAMLScalars(doc.Content[0], nil, false, re, func(n *yaml.Node, path []string) error {
+		if n.Tag == "!!null" {
+			return nil
+		}
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+		enc, err := c.encryptValue(v, path)
+		if err != nil {
+			return err
+		}
+		n.Tag, n.Value, n.Style = "!!str", enc, 0
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var key, value yaml.Node
+	key.SetString(FieldMetadataKey)
+	if err := value.Encode(meta); err != nil {
+		return nil, err
+	}
+	root := doc.Content[0]
+	root.Content = append(root.Content, &key, &value)
+	return marshalYAMLNode(doc)
+}
+
+// openYAMLDocument parses a YAML document and, when it has a metadata block,
+// decrypts its values in place and removes the block. It returns the stored key regex.
+func openYAMLDocument(raw []byte, kr *Keyring) (*yaml.Node, string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, "", err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, "", fmt.Errorf("field encryption needs a mapping at the top level")
+	}
+
+	metaIndex := -1
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == FieldMetadataKey && isYAMLFieldMetadata(root.Content[i+1]) {
+			metaIndex = i
+		}
+	}
+	if metaIndex < 0 {
+		if kr != nil {
+			var tree interface{}
+			if err := doc.Decode(&tree); err != nil {
+				return nil, "", err
+			}
+			if hasEncryptedValues(tree) {
+				return nil, "", errNoFieldMetadata
+			}
+		}
+		return &doc, "", nil
+	}
+
+	var rawMeta interface{}
+	if err := root.Content[metaIndex+1].Decode(&rawMeta); err != nil {
+		return nil, "", err
+	}
+	meta, p, salt, err := parseFieldMetadata(rawMeta)
+	if err != nil {
+		return nil, "", err
+	}
+	root.Content = append(root.Content[:metaIndex], root.Content[metaIndex+2:]...)
+
+	var plainDoc *yaml.Node
+	_, err = openDocument(kr, meta, p, salt, func(c *fieldCipher) (map[string]interface{}, error) {
+		d := cloneYAMLNode(&doc)
+		err := walkYAMLScalars(d.Content[0], nil, true, nil, func(n *yaml.Node, path []string) error {
+			if !isEncryptedValue(n.Value) || n.Tag != "!!str" {
+				return nil
+			}
+			v, err := c.decryptValue(n.Value, path)
+			if err != nil {
+				return err
+			}
+			var plain yaml.Node
+			if err := plain.Encode(v); err != nil {
+				return err
+			}
+			n.Tag, n.Value, n.Style = plain.Tag, plain.Value, plain.Style
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		tree := make(map[string]interface{})
+		if err := d.Decode(&tree); err != nil {
+			return nil, err
+		}
+		plainDoc = d
+		return tree, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return plainDoc, meta.EncryptedRegex, nil
+}
+
+// walkYAMLScalars calls fn for every scalar below a key matching re (or every
+// scalar when match is set). The metadata block is skipped, and so are
+// aliases: their anchors are visited where they are defined. When encrypting
+// (re set), an alias below a matching key is an error, as its value would
+// stay in the clear.
+func walkYAMLScalars(n *yaml.Node, path []string, match bool, re *regexp.Regexp, fn func(*yaml.Node, []string) error) error {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i].Value
+			if len(path) == 0 && k == FieldMetadataKey {
+				continue
+			}
+			m := match || (re != nil && re.MatchString(k))
+			if err := walkYAMLScalars(n.Content[i+1], appendPath(path, k), m, re, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, e := range n.Content {
+			if err := walkYAMLScalars(e, appendPath(path, strconv.Itoa(i)), match, re, fn); err != nil {
+				return err
+			}
+		}
+	case yaml.AliasNode:
+		if match && re != nil {
+			return fmt.Errorf("%s: aliases cannot be encrypted", strings.Join(path, "."))
+		}
+	case yaml.ScalarNode:
+		if match {
+			return fn(n, path)
+		}
+	}
+	return nil
+}
+
+// cloneYAMLNode deep-copies n; aliases point to the copies of their anchors.
+func cloneYAMLNode(n *yaml.Node) *yaml.Node {
+	return cloneYAMLNodes(n, make(map[*yaml.Node]*yaml.Node))
+}
+
+func cloneYAMLNodes(n *yaml.Node, clones map[*yaml.Node]*yaml.Node) *yaml.Node {
+	c := *n
+	clones[n] = &c
+	if c.Alias != nil {
+		// anchors come before their aliases in document order
+		if a, ok := clones[c.Alias]; ok {
+			c.Alias = a
+		}
+	}
+	c.Content = make([]*yaml.Node, len(n.Content))
+	for i, e := range n.Content {
+		c.Content[i] = cloneYAMLNodes(e, clones)
+	}
+	return &c
+}
+
+func marshalYAMLNode(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
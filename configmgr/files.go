@@ -1,54 +1,183 @@
 package configmgr
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
 )
 
-// LoadFromFile loads configuration from a JSON or YAML file.
+// LoadFromFile loads configuration from a JSON, YAML or TOML file.
+// Nested objects are deep-merged into the existing tree.
 func (cm *ConfigManager) LoadFromFile(path string) error {
-	raw, err := os.ReadFile(path)
-	if err != nil {
+	if err := cm.Load(context.Background(), FileSource(path)); err != nil {
+		return err
+	}
+	cm.logInfo("load_from_file_success", map[string]interface{}{"path": path})
+	return nil
+}
+
+// LoadFiles loads multiple config files in order.
+// Later files override earlier ones.
+func (cm *ConfigManager) LoadFiles(paths ...string) error {
+	for _, path := range paths {
+		if err := cm.LoadFromFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadFromFS is LoadFromFile for a file of fsys, e.g. an embed.FS with built-in defaults.
+func (cm *ConfigManager) LoadFromFS(fsys fs.FS, path string) error {
+	if err := cm.Load(context.Background(), FileSourceFS(fsys, path)); err != nil {
 		return err
 	}
+	cm.logInfo("load_from_file_success", map[string]interface{}{"path": path})
+	return nil
+}
+
+// FileSource returns a Source reading a JSON, YAML or TOML file.
+func FileSource(path string) Source {
+	return &fileSource{path: path}
+}
+
+// FileSourceFS is FileSource for a file of fsys. Files of an fs.FS are not watched.
+func FileSourceFS(fsys fs.FS, path string) Source {
+	return &fileSource{fsys: fsys, path: path}
+}
+
+type fileSource struct {
+	fsys fs.FS // nil for the OS file system
+	path string
+}
+
+func (s *fileSource) Name() string                { return "file:" + s.path }
+func (s *fileSource) Paths() []string             { return osPaths(s.fsys, s.path) }
+func (s *fileSource) origin() (kind, path string) { return "file", s.path }
+
+func (s *fileSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *fileSource) load(ctx context.Context, opts loadOptions) (loaded, error) {
+	return readConfigFile(ctx, s.fsys, s.path, opts.keys)
+}
 
-	ext := strings.ToLower(filepath.Ext(path))
+// readConfigFile reads and decodes a JSON, YAML or TOML file into a normalized
+// tree. Encrypted values are decrypted with keys.
+func readConfigFile(ctx context.Context, fsys fs.FS, path string, keys KeyProvider) (loaded, error) {
+	raw, err := readFile(fsys, path)
+	if err != nil {
+		return loaded{}, err
+	}
+	return decodeConfig(ctx, raw, strings.ToLower(filepath.Ext(path)), keys)
+}
+
+// readFile reads a file of fsys, or of the OS file system when fsys is nil.
+func readFile(fsys fs.FS, path string) ([]byte, error) {
+	if fsys == nil {
+		return os.ReadFile(path)
+	}
+	return fs.ReadFile(fsys, path)
+}
+
+// osPaths returns path for Watch when it is a file of the OS file system.
+func osPaths(fsys fs.FS, path string) []string {
+	if fsys != nil {
+		return nil
+	}
+	return []string{path}
+}
+
+// decodeConfig decodes raw JSON, YAML or TOML, selected by file extension,
+// and records the line of every key. JSON and YAML documents with encrypted
+// values (see EncryptFields) are decrypted with keys.
+func decodeConfig(ctx context.Context, raw []byte, ext string, keys KeyProvider) (loaded, error) {
 	tmp := make(map[string]interface{})
+	var lines map[string]int
 
 	switch ext {
 	case ".json":
-		if err = json.Unmarshal(raw, &tmp); err != nil {
-			return err
+		if err := json.Unmarshal(raw, &tmp); err != nil {
+			return loaded{}, err
 		}
+		lines = jsonLines(raw)
 	case ".yaml", ".yml":
-		if err = yaml.Unmarshal(raw, &tmp); err != nil {
-			return err
+		if err := yaml.Unmarshal(raw, &tmp); err != nil {
+			return loaded{}, err
 		}
+		lines = yamlLines(raw)
+	case ".toml":
+		md, err := toml.Decode(string(raw), &tmp)
+		if err != nil {
+			return loaded{}, err
+		}
+		tmp = tomlTree(tmp)
+		lines = tomlLines(raw, md)
 	default:
-		return fmt.Errorf("unsupported file type: %s", ext)
+		return loaded{}, fmt.Errorf("unsupported file type: %s", ext)
 	}
 
-	for k, v := range tmp {
-		cm.data[normalizeKey(k)] = normalizeValue(v)
+	var secrets map[string]bool
+	if _, ok := fieldMetadataOf(tmp); ok && ext != ".toml" {
+		kr, err := resolveKeys(ctx, keys)
+		if err != nil {
+			return loaded{}, err
+		}
+		secrets = make(map[string]bool)
+		encryptedPaths(tmp, nil, secrets)
+		if ext == ".json" {
+			tmp, err = decryptFieldTree(tmp, kr)
+		} else {
+			tmp, err = decryptYAMLFields(raw, kr)
+		}
+		if err != nil {
+			return loaded{}, err
+		}
+	} else if keys != nil && ext != ".toml" && hasEncryptedValues(tmp) {
+		return loaded{}, errNoFieldMetadata
 	}
-	if cm.logger != nil {
-		cm.logger.Info("load_from_file_success", map[string]interface{}{"path": path})
+	return loaded{tree: normalizeTree(tmp), lines: lines, secrets: secrets}, nil
+}
+
+// tomlTree converts TOML specific values into the types used by the other
+// formats: integers become int and arrays of tables []interface{}.
+func tomlTree(src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		src[k] = tomlValue(v)
 	}
-	return nil
+	return src
 }
 
-// LoadFiles loads multiple config files in order.
-// Later files override earlier ones.
-func (cm *ConfigManager) LoadFiles(paths ...string) error {
-	for _, path := range paths {
-		if err := cm.LoadFromFile(path); err != nil {
-			return err
+func tomlValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int64:
+		if t >= math.MinInt && t <= math.MaxInt {
+			return int(t)
+		}
+		return t
+	case map[string]interface{}:
+		return tomlTree(t)
+	case []map[string]interface{}:
+		out := make([]interface{}, len(t))
+		for i, m := range t {
+			out[i] = tomlTree(m)
 		}
+		return out
+	case []interface{}:
+		for i, e := range t {
+			t[i] = tomlValue(e)
+		}
+		return t
+	default:
+		return v
 	}
-	return nil
 }
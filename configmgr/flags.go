@@ -0,0 +1,284 @@
+package configmgr
+
+import (
+	"context"
+	"encoding"
+	"flag"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flagKind is the origin kind of command-line flags.
+const flagKind = "flag"
+
+// LoadFromFlags loads the flags of a parsed flag set that were set on the
+// command line. Flag names are key paths ("-database.host=db" sets
+// "database.host"); flags that were not set leave the keys of earlier
+// sources alone. Flag layers have the highest priority, so set flags win over
+// sources loaded later, Set and AutomaticEnv.
+//
+// With names, only those flags are loaded, e.g. when the set also holds
+// flags that are not config keys.
+func (cm *ConfigManager) LoadFromFlags(fs *flag.FlagSet, names ...string) error {
+	if err := cm.Load(context.Background(), FlagSource(fs, names...)); err != nil {
+		return err
+	}
+	cm.logInfo("load_flags_success", map[string]interface{}{"flags": fs.Name()})
+	return nil
+}
+
+// FlagSource returns a Source reading the set flags of fs, as described for
+// LoadFromFlags. fs must be parsed before the source is loaded; Reload reads
+// the same flags again. Its priority is math.MaxInt unless set with WithPriority.
+func FlagSource(fs *flag.FlagSet, names ...string) Source {
+	return &flagSource{fs: fs, names: names}
+}
+
+type flagSource struct {
+	fs    *flag.FlagSet
+	names []string // all flags when empty
+}
+
+func (s *flagSource) Name() string                { return "flags:" + s.fs.Name() }
+func (s *flagSource) origin() (kind, path string) { return flagKind, s.fs.Name() }
+func (s *flagSource) defaultPriority() int        { return math.MaxInt }
+
+func (s *flagSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *flagSource) load(_ context.Context, opts loadOptions) (loaded, error) {
+	if !s.fs.Parsed() {
+		return loaded{}, fmt.Errorf("flags %s: not parsed", s.fs.Name())
+	}
+	tree := make(map[string]interface{})
+	s.fs.Visit(func(f *flag.Flag) {
+		if len(s.names) > 0 && !containsString(s.names, f.Name) {
+			return
+		}
+		if kv, ok := f.Value.(*KeyValues); ok {
+			for _, pair := range kv.pairs {
+				setPath(tree, splitKeyPath(pair[0], opts.delimiter), normalizeValue(pair[1]))
+			}
+			return
+		}
+		setPath(tree, splitKeyPath(f.Name, opts.delimiter), flagValue(f.Value))
+	})
+	return loaded{tree: tree}, nil
+}
+
+// flagValue returns the value of a flag: the typed value of the standard
+// flag types, the text of the others.
+func flagValue(v flag.Value) interface{} {
+	if g, ok := v.(flag.Getter); ok {
+		switch t := g.Get().(type) {
+		case bool, int, int64, uint, uint64, float64, string:
+			return t
+		}
+	}
+	return v.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyValues is a flag.Value collecting KEY=VALUE pairs of a repeatable flag,
+// so that any key can be overridden from the command line:
+//
+//	fs.Var(&configmgr.KeyValues{}, "set", "override a config key: KEY=VALUE (repeatable)")
+//
+// FlagSource loads every pair as a key; values are normalized like those given to Set.
+type KeyValues struct {
+	pairs [][2]string
+}
+
+func (kv *KeyValues) String() string {
+	if kv == nil {
+		return ""
+	}
+	items := make([]string, len(kv.pairs))
+	for i, p := range kv.pairs {
+		items[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(items, ",")
+}
+
+// Set adds a KEY=VALUE pair.
+func (kv *KeyValues) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if key = strings.TrimSpace(key); !ok || key == "" {
+		return fmt.Errorf("want KEY=VALUE, got %q", s)
+	}
+	kv.pairs = append(kv.pairs, [2]string{key, value})
+	return nil
+}
+
+// DefineFlags defines a flag on fs for every key of the config struct cfg,
+// named after the key path as Unmarshal reads it: "-database.host" for the
+// Host field of a Database struct. The usage text comes from the `desc` tag
+// and the default shown in the help from the `default` tag.
+//
+// Flag values are checked against the field type when parsed. Load the
+// parsed set with LoadFromFlags; defaults are applied by Unmarshal, so only
+// the flags given on the command line override other sources. Maps and lists
+// of structs have no flag.
+func (cm *ConfigManager) DefineFlags(fs *flag.FlagSet, cfg interface{}) error {
+	t := reflect.TypeOf(cfg)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("flags source must be a struct, got %T", cfg)
+	}
+	cm.mu.RLock()
+	delimiter := cm.delimiter
+	cm.mu.RUnlock()
+	return defineStructFlags(fs, t, "", delimiter, map[reflect.Type]bool{})
+}
+
+func defineStructFlags(fs *flag.FlagSet, t reflect.Type, prefix, delimiter string, seen map[reflect.Type]bool) error {
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for _, fp := range planFor(t) {
+		sf := t.Field(fp.index)
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if fp.squash {
+			if err := defineStructFlags(fs, ft, prefix, delimiter, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		name := strings.ToLower(fp.label)
+		if prefix != "" {
+			name = prefix + delimiter + name
+		}
+		if decodesAsStruct(ft) {
+			if err := defineStructFlags(fs, ft, name, delimiter, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		parse := flagParser(ft)
+		if parse == nil {
+			continue
+		}
+		if fs.Lookup(name) != nil {
+			return fmt.Errorf("flag redefined: %s", name)
+		}
+		fs.Var(&fieldFlag{text: sf.Tag.Get("default"), parse: parse, isBool: ft.Kind() == reflect.Bool},
+			name, sf.Tag.Get("desc"))
+	}
+	return nil
+}
+
+// fieldFlag is the flag.Value of a struct field, see DefineFlags.
+type fieldFlag struct {
+	text   string
+	value  interface{}
+	parse  func(string) (interface{}, error)
+	isBool bool
+}
+
+func (f *fieldFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.text
+}
+
+func (f *fieldFlag) Set(s string) error {
+	v, err := f.parse(s)
+	if err != nil {
+		return err
+	}
+	f.text, f.value = s, v
+	return nil
+}
+
+func (f *fieldFlag) Get() interface{} { return f.value }
+func (f *fieldFlag) IsBoolFlag() bool { return f.isBool }
+
+// flagParser returns the parser of flag values for fields of type t, or nil
+// when t cannot be given as a single flag.
+func flagParser(t reflect.Type) func(string) (interface{}, error) {
+	text := func(s string) (interface{}, error) { return s, nil }
+	switch t {
+	case durationType:
+		return func(s string) (interface{}, error) {
+			_, err := time.ParseDuration(s)
+			return s, err
+		}
+	case secretType, timeType, urlType:
+		return text
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return func(s string) (interface{}, error) {
+			err := reflect.New(t).Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+			return s, err
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String, reflect.Interface:
+		return text
+	case reflect.Bool:
+		return func(s string) (interface{}, error) { return strconv.ParseBool(s) }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(s string) (interface{}, error) {
+			if _, err := strconv.ParseInt(s, 10, t.Bits()); err != nil {
+				return nil, numError(err)
+			}
+			return normalizeValue(s), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(s string) (interface{}, error) {
+			if _, err := strconv.ParseUint(s, 10, t.Bits()); err != nil {
+				return nil, numError(err)
+			}
+			return normalizeValue(s), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(s string) (interface{}, error) {
+			f, err := strconv.ParseFloat(s, t.Bits())
+			if err != nil {
+				return nil, numError(err)
+			}
+			return f, nil
+		}
+	case reflect.Slice, reflect.Array:
+		// comma-separated, split by Unmarshal
+		elem := t.Elem()
+		for elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if !decodesAsStruct(elem) && elem.Kind() != reflect.Map {
+			return text
+		}
+	}
+	return nil
+}
+
+// numError drops the strconv prefix; the flag package names the flag and value.
+func numError(err error) error {
+	if ne, ok := err.(*strconv.NumError); ok {
+		return ne.Err
+	}
+	return err
+}
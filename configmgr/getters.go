@@ -0,0 +1,219 @@
+package configmgr
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrKeyNotFound is returned by the typed ...E getters when a key is not set.
+var ErrKeyNotFound = errors.New("configmgr: key not found")
+
+// ErrSecretKey is returned by the typed ...E getters for secret keys (see
+// Secret): their value is read explicitly with Reveal(key).
+var ErrSecretKey = errors.New("configmgr: key is secret, read it with Reveal")
+
+// getAs looks up key and converts it with conv. Secret keys fail with
+// ErrSecretKey; secret values below a key stay wrapped in Secret.
+func getAs[T any](cm *ConfigManager, key string, conv func(interface{}) (T, error)) (T, error) {
+	var zero T
+	cm.mu.RLock()
+	path := cm.keyPath(key)
+	v, ok := cm.value(path)
+	secret := ok && cm.isSecret(path)
+	if ok && !secret {
+		v = cm.mask(v, path)
+	}
+	cm.mu.RUnlock()
+	if !ok || v == nil {
+		return zero, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	if secret {
+		return zero, fmt.Errorf("%w: %s", ErrSecretKey, key)
+	}
+	out, err := conv(v)
+	if err != nil {
+		return zero, fmt.Errorf("configmgr: key %s: %w", key, err)
+	}
+	return out, nil
+}
+
+// GetStringE returns the value of key converted to a string.
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetStringE(key string) (string, error) {
+	return getAs(cm, key, toString)
+}
+
+// GetString is like GetStringE but returns the zero value on error.
+func (cm *ConfigManager) GetString(key string) string {
+	v, _ := cm.GetStringE(key)
+	return v
+}
+
+// GetStringOr is like GetStringE but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetStringOr(key string, def string) string {
+	if v, err := cm.GetStringE(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetIntE returns the value of key converted to an int.
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetIntE(key string) (int, error) {
+	return getAs(cm, key, toInt)
+}
+
+// GetInt is like GetIntE but returns the zero value on error.
+func (cm *ConfigManager) GetInt(key string) int {
+	v, _ := cm.GetIntE(key)
+	return v
+}
+
+// GetIntOr is like GetIntE but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetIntOr(key string, def int) int {
+	if v, err := cm.GetIntE(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetInt64E returns the value of key converted to an int64.
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetInt64E(key string) (int64, error) {
+	return getAs(cm, key, toInt64)
+}
+
+// GetInt64 is like GetInt64E but returns the zero value on error.
+func (cm *ConfigManager) GetInt64(key string) int64 {
+	v, _ := cm.GetInt64E(key)
+	return v
+}
+
+// GetInt64Or is like GetInt64E but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetInt64Or(key string, def int64) int64 {
+	if v, err := cm.GetInt64E(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetFloat64E returns the value of key converted to a float64.
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetFloat64E(key string) (float64, error) {
+	return getAs(cm, key, toFloat64)
+}
+
+// GetFloat64 is like GetFloat64E but returns the zero value on error.
+func (cm *ConfigManager) GetFloat64(key string) float64 {
+	v, _ := cm.GetFloat64E(key)
+	return v
+}
+
+// GetFloat64Or is like GetFloat64E but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetFloat64Or(key string, def float64) float64 {
+	if v, err := cm.GetFloat64E(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetBoolE returns the value of key converted to a bool. Besides strconv.ParseBool forms, "yes"/"no" and "on"/"off" are accepted.
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetBoolE(key string) (bool, error) {
+	return getAs(cm, key, toBool)
+}
+
+// GetBool is like GetBoolE but returns the zero value on error.
+func (cm *ConfigManager) GetBool(key string) bool {
+	v, _ := cm.GetBoolE(key)
+	return v
+}
+
+// GetBoolOr is like GetBoolE but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetBoolOr(key string, def bool) bool {
+	if v, err := cm.GetBoolE(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetDurationE returns the value of key converted to a time.Duration. Strings use time.ParseDuration ("5s"), plain numbers are seconds.
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetDurationE(key string) (time.Duration, error) {
+	return getAs(cm, key, toDuration)
+}
+
+// GetDuration is like GetDurationE but returns the zero value on error.
+func (cm *ConfigManager) GetDuration(key string) time.Duration {
+	v, _ := cm.GetDurationE(key)
+	return v
+}
+
+// GetDurationOr is like GetDurationE but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetDurationOr(key string, def time.Duration) time.Duration {
+	if v, err := cm.GetDurationE(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetTimeE returns the value of key converted to a time.Time. RFC 3339, "2006-01-02" style dates and Unix seconds are accepted.
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetTimeE(key string) (time.Time, error) {
+	return getAs(cm, key, toTime)
+}
+
+// GetTime is like GetTimeE but returns the zero value on error.
+func (cm *ConfigManager) GetTime(key string) time.Time {
+	v, _ := cm.GetTimeE(key)
+	return v
+}
+
+// GetTimeOr is like GetTimeE but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetTimeOr(key string, def time.Time) time.Time {
+	if v, err := cm.GetTimeE(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetStringSliceE returns the value of key converted to a []string. A string value is split on commas.
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetStringSliceE(key string) ([]string, error) {
+	return getAs(cm, key, toStringSlice)
+}
+
+// GetStringSlice is like GetStringSliceE but returns the zero value on error.
+func (cm *ConfigManager) GetStringSlice(key string) []string {
+	v, _ := cm.GetStringSliceE(key)
+	return v
+}
+
+// GetStringSliceOr is like GetStringSliceE but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetStringSliceOr(key string, def []string) []string {
+	if v, err := cm.GetStringSliceE(key); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetStringMapE returns the value of key converted to a map. A string value is parsed as "k1=v1,k2=v2".
+// It fails with ErrKeyNotFound when the key is not set.
+func (cm *ConfigManager) GetStringMapE(key string) (map[string]interface{}, error) {
+	return getAs(cm, key, toStringMap)
+}
+
+// GetStringMap is like GetStringMapE but returns the zero value on error.
+func (cm *ConfigManager) GetStringMap(key string) map[string]interface{} {
+	v, _ := cm.GetStringMapE(key)
+	return v
+}
+
+// GetStringMapOr is like GetStringMapE but returns def when the key is missing or cannot be converted.
+func (cm *ConfigManager) GetStringMapOr(key string, def map[string]interface{}) map[string]interface{} {
+	if v, err := cm.GetStringMapE(key); err == nil {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,284 @@
+package configmgr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Interpolation expands references in string values once all sources are merged:
+//
+//	${database.host}          another config key (key path, any case)
+//	${env:DB_USER}            an environment variable
+//	${database.port:-5432}    a default when the reference is unset or empty
+//	${env:DB_PASSWORD:?required}  an error when it is unset or empty
+//	$${literal}               the escape for a literal "${literal}"
+//
+// Defaults may contain references themselves. A value that is a single
+// reference keeps the type of the referenced value (e.g. an int or a map);
+// otherwise the parts are joined into a string. References are resolved
+// recursively; a cycle is an error naming the chain of keys. A value
+// referencing a secret is secret itself.
+
+// EnableInterpolation turns on interpolation of config values. Get, the typed
+// getters, GetAll, Unmarshal and exports return resolved values, GetRaw the
+// values as loaded.
+//
+// With interpolation enabled, Load and Reload fail and leave the
+// configuration unchanged when a reference cannot be resolved.
+func (cm *ConfigManager) EnableInterpolation() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.interpolate = true
+	if err := cm.resolve(); err != nil {
+		cm.interpolate = false
+		cm.data = cm.raw
+		return err
+	}
+	return nil
+}
+
+// GetRaw returns the value stored at key before interpolation.
+func (cm *ConfigManager) GetRaw(key string) interface{} {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	path := cm.keyPath(key)
+	v, _ := lookupPath(cm.raw, path)
+	return cm.mask(v, path)
+}
+
+// resolve computes cm.data from cm.raw. On error cm.data holds the raw
+// values. The caller must hold cm.mu.
+func (cm *ConfigManager) resolve() error {
+	cm.derived = nil
+	if !cm.interpolate {
+		cm.data = cm.raw
+		return nil
+	}
+	ip := &interpolator{cm: cm, done: make(map[string]interface{}), active: make(map[string]bool), tainted: make(map[string]bool)}
+	resolved, err := ip.resolveTree(cm.raw, nil)
+	if err != nil {
+		cm.data = cm.raw
+		return err
+	}
+	cm.data = resolved.(map[string]interface{})
+	cm.derived = ip.tainted
+	return nil
+}
+
+// interpolator resolves the references of one config tree.
+type interpolator struct {
+	cm      *ConfigManager
+	done    map[string]interface{} // resolved string values by pathKey
+	active  map[string]bool        // pathKeys of the values being resolved
+	stack   []string               // keys being resolved, in order, for messages
+	paths   [][]string             // split stack entries
+	tainted map[string]bool        // pathKeys of values referencing secrets
+}
+
+func (ip *interpolator) resolveTree(v interface{}, path []string) (interface{}, error) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		// sorted, so errors are reproducible
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := make(map[string]interface{}, len(t))
+		for _, k := range keys {
+			r, err := ip.resolveTree(t[k], appendPath(path, k))
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			r, err := ip.resolveTree(e, appendPath(path, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case string:
+		return ip.resolveString(path, t)
+	default:
+		return v, nil
+	}
+}
+
+func (ip *interpolator) resolveString(path []string, s string) (interface{}, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	// keyed by pathKey: a flat "A.B" key and the nested A -> B are different values
+	key := pathKey(path)
+	id := strings.Join(path, ip.cm.delimiter)
+	if v, ok := ip.done[key]; ok {
+		if ip.tainted[key] {
+			ip.taint()
+		}
+		return v, nil
+	}
+	if ip.active[key] {
+		chain := ip.stack
+		for i, p := range ip.paths {
+			if pathKey(p) == key {
+				chain = ip.stack[i:]
+				break
+			}
+		}
+		return nil, fmt.Errorf("interpolation cycle: %s -> %s", strings.Join(chain, " -> "), id)
+	}
+
+	ip.active[key] = true
+	ip.stack = append(ip.stack, id)
+	ip.paths = append(ip.paths, path)
+	v, err := ip.expand(s)
+	ip.stack = ip.stack[:len(ip.stack)-1]
+	ip.paths = ip.paths[:len(ip.paths)-1]
+	delete(ip.active, key)
+	if err != nil {
+		return nil, err
+	}
+	ip.done[key] = v
+	return v, nil
+}
+
+// taint marks the values being resolved as secret.
+func (ip *interpolator) taint() {
+	for _, p := range ip.paths {
+		ip.tainted[pathKey(p)] = true
+	}
+}
+
+// current returns the key path being resolved, for error messages.
+func (ip *interpolator) current() string {
+	if len(ip.stack) == 0 {
+		return "interpolation"
+	}
+	return ip.stack[len(ip.stack)-1]
+}
+
+// expand replaces the references in s.
+func (ip *interpolator) expand(s string) (interface{}, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "$${"):
+			b.WriteString("${")
+			i += 3
+		case strings.HasPrefix(s[i:], "${"):
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				return nil, fmt.Errorf("%s: unterminated reference in %q", ip.current(), s)
+			}
+			v, err := ip.reference(s[i+2 : end])
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 && end == len(s)-1 {
+				return v, nil // a single reference keeps its type
+			}
+			str, err := toString(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: cannot insert ${%s} into a string: %w", ip.current(), s[i+2:end], err)
+			}
+			b.WriteString(str)
+			i = end + 1
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return normalizeValue(b.String()), nil
+}
+
+// closingBrace returns the index of the "}" closing the reference whose
+// body starts at start, or -1.
+func closingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// reference resolves the body of a ${...} reference.
+func (ip *interpolator) reference(expr string) (interface{}, error) {
+	name, env := strings.CutPrefix(expr, "env:")
+	var op, arg string
+	for i := 0; i+1 < len(name); i++ {
+		if name[i] == ':' && (name[i+1] == '-' || name[i+1] == '?') {
+			name, op, arg = name[:i], name[i:i+2], name[i+2:]
+			break
+		}
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%s: empty reference ${%s}", ip.current(), expr)
+	}
+
+	var v interface{}
+	var ok bool
+	if env {
+		var variable string
+		if v, variable, ok = lookupEnv(name, ip.cm.envFiles); ok && variable != name {
+			ip.taint() // read from a FOO_FILE secret
+		}
+	} else {
+		var err error
+		if v, ok, err = ip.lookup(name); err != nil {
+			return nil, err
+		}
+	}
+	if ok && (v == nil || v == "") && op != "" {
+		ok = false
+	}
+
+	switch {
+	case ok:
+		return v, nil
+	case op == ":-":
+		return ip.expand(arg)
+	case op == ":?":
+		msg := arg
+		if msg == "" {
+			msg = name + " is not set"
+		}
+		return nil, fmt.Errorf("%s: %s", ip.current(), msg)
+	case env:
+		return nil, fmt.Errorf("%s: environment variable %s is not set", ip.current(), name)
+	default:
+		return nil, fmt.Errorf("%s: reference to undefined key %s", ip.current(), name)
+	}
+}
+
+// lookup resolves the config key name, honouring AutomaticEnv.
+func (ip *interpolator) lookup(name string) (interface{}, bool, error) {
+	path := ip.cm.keyPath(name)
+	if ip.cm.isSecret(path) {
+		ip.taint()
+	}
+	if v, ok := ip.cm.envOverride(path); ok {
+		return v, true, nil
+	}
+	v, ok := lookupPath(ip.cm.raw, path)
+	if !ok {
+		return nil, false, nil
+	}
+	r, err := ip.resolveTree(v, path)
+	return r, true, err
+}
@@ -0,0 +1,153 @@
+package configmgr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadFromKeyPerFileDir loads a directory holding one file per key, as mounted
+// for Docker secrets (/run/secrets/db_password) and Kubernetes Secret or
+// ConfigMap volumes. The file name is the key ("database.password" nests with
+// the default delimiter) and the content, without trailing newlines, the value.
+// Values are kept verbatim and are secret (see Secret).
+//
+// Kubernetes updates such volumes by pointing the ..data symlink to a new
+// directory; Watch notices the flip and Reload reads the new files, including
+// keys added or removed since the last load.
+func (cm *ConfigManager) LoadFromKeyPerFileDir(dir string) error {
+	if err := cm.Load(context.Background(), KeyPerFileSource(dir)); err != nil {
+		return err
+	}
+	cm.logInfo("load_key_per_file_success", map[string]interface{}{"path": dir})
+	return nil
+}
+
+// KeyPerFileSource returns a Source reading a directory with one file per key,
+// as described for LoadFromKeyPerFileDir.
+func KeyPerFileSource(dir string) Source {
+	return &keyPerFileSource{dir: dir}
+}
+
+type keyPerFileSource struct {
+	dir string
+}
+
+func (s *keyPerFileSource) Name() string                { return "keyfile:" + s.dir }
+func (s *keyPerFileSource) origin() (kind, path string) { return "keyfile", s.dir }
+
+// Paths returns the directory, the ..data symlink of Kubernetes volumes and
+// every key file. Watch follows symlinks, so the flip of ..data changes them all.
+func (s *keyPerFileSource) Paths() []string {
+	paths := []string{s.dir}
+	if _, err := os.Lstat(filepath.Join(s.dir, "..data")); err == nil {
+		paths = append(paths, filepath.Join(s.dir, "..data"))
+	}
+	names, _ := s.keyFiles()
+	for _, name := range names {
+		paths = append(paths, filepath.Join(s.dir, name))
+	}
+	return paths
+}
+
+func (s *keyPerFileSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *keyPerFileSource) load(_ context.Context, opts loadOptions) (loaded, error) {
+	names, err := s.keyFiles()
+	if err != nil {
+		return loaded{}, err
+	}
+	tree := make(map[string]interface{}, len(names))
+	secrets := make(map[string]bool, len(names))
+	for _, name := range names {
+		val, err := readValueFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return loaded{}, err
+		}
+		path := splitKeyPath(name, opts.delimiter)
+		setPath(tree, path, val)
+		secrets[pathKey(path)] = true
+	}
+	return loaded{tree: tree, secrets: secrets}, nil
+}
+
+// keyFiles lists the key files of the directory, sorted. Hidden entries, such
+// as the ..data link and the timestamped directories of Kubernetes volumes,
+// and subdirectories are skipped; symlinks to files are followed.
+func (s *keyPerFileSource) keyFiles() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(s.dir, e.Name()))
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+// readValueFile reads a value from a file, without trailing newlines.
+func readValueFile(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(raw), "\r\n"), nil
+}
+
+// envFileSuffix marks variables naming the file that holds a value, see EnableEnvFiles.
+const envFileSuffix = "_FILE"
+
+// EnableEnvFiles turns on the FOO_FILE convention for environment variables:
+// when FOO is not set but FOO_FILE=/run/secrets/foo is, FOO is read from that
+// file, without trailing newlines. It applies to LoadFromSysEnv,
+// LoadFromSysEnvPrefix, AutomaticEnv and ${env:FOO} references. Values read
+// from files are secret (see Secret).
+//
+// Call it before loading.
+func (cm *ConfigManager) EnableEnvFiles() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.envFiles = true
+}
+
+// lookupEnv returns the value of the environment variable name and the
+// variable it was read from: name, or name+"_FILE" with EnableEnvFiles.
+// An unreadable file counts as unset.
+func lookupEnv(name string, files bool) (value, variable string, ok bool) {
+	if v, ok := os.LookupEnv(name); ok {
+		return v, name, true
+	}
+	if !files {
+		return "", "", false
+	}
+	file, ok := os.LookupEnv(name + envFileSuffix)
+	if !ok {
+		return "", "", false
+	}
+	v, err := readValueFile(file)
+	if err != nil {
+		return "", "", false
+	}
+	return v, name + envFileSuffix, true
+}
+
+// readEnvFile reads the value of variable, a FOO_FILE variable.
+func readEnvFile(variable string) (string, error) {
+	v, err := readValueFile(os.Getenv(variable))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", variable, err)
+	}
+	return v, nil
+}
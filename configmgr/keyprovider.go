@@ -0,0 +1,129 @@
+package configmgr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// KeyProvider supplies the keys that decrypt config files. It is asked on
+// every load, so a reload picks up a changed secret.
+//
+// A *Keyring is a KeyProvider; EnvKey, FileKey, CommandKey and KMSKey fetch a
+// secret from elsewhere, NamedKey gives it a key id and CombineKeys builds a
+// keyring from several providers.
+type KeyProvider interface {
+	Keys(ctx context.Context) (*Keyring, error)
+}
+
+// Keys returns kr itself.
+func (kr *Keyring) Keys(context.Context) (*Keyring, error) {
+	return kr, nil
+}
+
+// KeyProviderFunc adapts a function to a KeyProvider.
+type KeyProviderFunc func(ctx context.Context) (*Keyring, error)
+
+func (f KeyProviderFunc) Keys(ctx context.Context) (*Keyring, error) { return f(ctx) }
+
+// secretProvider turns a function returning a single secret into a KeyProvider.
+func secretProvider(source string, fetch func(ctx context.Context) (string, error)) KeyProvider {
+	return KeyProviderFunc(func(ctx context.Context) (*Keyring, error) {
+		secret, err := fetch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("key from %s: %w", source, err)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("key from %s is empty", source)
+		}
+		return NewKeyring(Key{Secret: secret})
+	})
+}
+
+// EnvKey reads the secret from the environment variable name.
+func EnvKey(name string) KeyProvider {
+	return secretProvider("$"+name, func(context.Context) (string, error) {
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New("variable is not set")
+		}
+		return secret, nil
+	})
+}
+
+// FileKey reads the secret from a file, e.g. a mounted Kubernetes or Docker
+// secret. A trailing newline is removed.
+func FileKey(path string) KeyProvider {
+	return secretProvider(path, func(context.Context) (string, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	})
+}
+
+// CommandKey runs a command and uses its output, without the trailing
+// newline, as the secret, e.g. CommandKey("pass", "show", "app/config").
+func CommandKey(name string, args ...string) KeyProvider {
+	return secretProvider("command "+name, func(ctx context.Context) (string, error) {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", fmt.Errorf("%w: %s", err, msg)
+			}
+			return "", err
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	})
+}
+
+// NamedKey gives the primary key of p the id id, see WithKeyID.
+func NamedKey(id string, p KeyProvider) KeyProvider {
+	return KeyProviderFunc(func(ctx context.Context) (*Keyring, error) {
+		kr, err := p.Keys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		primary, err := kr.Primary()
+		if err != nil {
+			return nil, err
+		}
+		primary.ID = id
+		return NewKeyring(primary, kr.keys[1:]...)
+	})
+}
+
+// CombineKeys builds one keyring from the keys of several providers. The
+// primary key of the first provider becomes the primary key.
+func CombineKeys(providers ...KeyProvider) KeyProvider {
+	return KeyProviderFunc(func(ctx context.Context) (*Keyring, error) {
+		var keys []Key
+		for _, p := range providers {
+			kr, err := p.Keys(ctx)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, kr.keys...)
+		}
+		if len(keys) == 0 {
+			return nil, errors.New("no key providers")
+		}
+		return NewKeyring(keys[0], keys[1:]...)
+	})
+}
+
+// resolveKeys asks p for its keys; a nil provider yields a nil keyring.
+func resolveKeys(ctx context.Context, p KeyProvider) (*Keyring, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return p.Keys(ctx)
+}
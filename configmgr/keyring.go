@@ -0,0 +1,196 @@
+package configmgr
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Key is a named secret used to encrypt config files. The id is written to
+// the file header (see WithKeyID); it is not secret.
+type Key struct {
+	ID     string
+	Secret string
+
+	dataKey bool // Secret is a random base64 data key, used without a KDF (KMSKey)
+}
+
+// Keyring holds the keys that may decrypt config files, so secrets can be
+// rotated without a flag day: deploy the new key as primary next to the old
+// one, re-encrypt the files (RotateFile, configctl rotate), then drop the old key.
+//
+// The primary key encrypts; every key decrypts. A file whose header names a
+// key id is decrypted with that key first, files without one with each key in turn.
+type Keyring struct {
+	keys []Key
+}
+
+// NewKeyring returns a keyring with a primary key and any number of older keys.
+// Key ids must be unique (several keys may have no id) and at most 255 bytes.
+func NewKeyring(primary Key, others ...Key) (*Keyring, error) {
+	keys := append([]Key{primary}, others...)
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Secret == "" {
+			return nil, fmt.Errorf("key %q has an empty secret", k.ID)
+		}
+		if len(k.ID) > 255 {
+			return nil, fmt.Errorf("key id %q is longer than 255 bytes", k.ID)
+		}
+		if k.ID != "" && seen[k.ID] {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		seen[k.ID] = true
+	}
+	return &Keyring{keys: keys}, nil
+}
+
+// ErrEmptyKeyring is returned when a keyring without keys, such as the zero
+// Keyring, is used to encrypt or decrypt.
+var ErrEmptyKeyring = errors.New("configmgr: keyring has no keys")
+
+// Primary returns the key used for encryption.
+func (kr *Keyring) Primary() (Key, error) {
+	if kr == nil || len(kr.keys) == 0 {
+		return Key{}, ErrEmptyKeyring
+	}
+	return kr.keys[0], nil
+}
+
+// Encrypt encrypts plaintext with the primary key and records its id.
+func (kr *Keyring) Encrypt(plaintext []byte, opts ...EncryptOption) ([]byte, error) {
+	p, err := kr.Primary()
+	if err != nil {
+		return nil, err
+	}
+	return EncryptBytes(plaintext, p.Secret, p.encryptOptions(opts)...)
+}
+
+// encryptOptions returns the options encrypting with k followed by opts:
+// its key id, and no KDF for a data key.
+func (k Key) encryptOptions(opts []EncryptOption) []EncryptOption {
+	base := []EncryptOption{WithKeyID(k.ID)}
+	if k.dataKey {
+		base = append(base, withDataKey())
+	}
+	return append(base, opts...)
+}
+
+// Decrypt decrypts data with the matching key of the keyring.
+func (kr *Keyring) Decrypt(data []byte) ([]byte, error) {
+	plaintext, _, err := kr.decrypt(data)
+	return plaintext, err
+}
+
+// NeedsRotation reports whether data must be re-encrypted to be in the
+// current format under the primary key. It fails when no key can decrypt data.
+func (kr *Keyring) NeedsRotation(data []byte) (bool, error) {
+	_, rotate, err := kr.rotation(data)
+	return rotate, err
+}
+
+// decrypt returns the plaintext of data and the key that decrypted it.
+func (kr *Keyring) decrypt(data []byte) ([]byte, Key, error) {
+	if kr == nil || len(kr.keys) == 0 {
+		return nil, Key{}, ErrEmptyKeyring
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, Key{}, err
+	}
+	id := parseHeader(raw).keyID
+	matched := false
+	for _, k := range kr.keys {
+		matched = matched || (id != "" && k.ID == id)
+	}
+
+	var lastErr error
+	for _, k := range kr.candidates(id) {
+		plaintext, err := open(raw, k.Secret)
+		if err == nil {
+			return plaintext, k, nil
+		}
+		if errors.Is(err, ErrUnsupportedEncryptionVersion) {
+			return nil, Key{}, err
+		}
+		lastErr = err
+	}
+	if id != "" && !matched {
+		return nil, Key{}, fmt.Errorf("no key with id %q in keyring: %w", id, lastErr)
+	}
+	return nil, Key{}, lastErr
+}
+
+// candidates returns the keys to try for data encrypted with key id: the keys
+// with that id first, then the others.
+func (kr *Keyring) candidates(id string) []Key {
+	keys := make([]Key, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		if id != "" && k.ID == id {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range kr.keys {
+		if id == "" || k.ID != id {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// rotation decrypts data and reports whether it must be re-encrypted.
+func (kr *Keyring) rotation(data []byte) ([]byte, bool, error) {
+	plaintext, key, err := kr.decrypt(data)
+	if err != nil {
+		return nil, false, err
+	}
+	version, _ := EncryptedVersion(data)
+	id, _ := EncryptedKeyID(data)
+	p, _ := kr.Primary() // decrypt failed if kr is empty
+	return plaintext, version != EncryptionFormatVersion || key != p || id != p.ID, nil
+}
+
+// RotateFile re-encrypts an encrypted file in place with the primary key of
+// kr, unless it already is encrypted with it in the current format. The file
+// is replaced atomically and keeps its permissions. It reports whether the
+// file was rewritten.
+func RotateFile(path string, kr *Keyring, opts ...EncryptOption) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	plaintext, rotate, err := kr.rotation(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	if !rotate {
+		return false, nil
+	}
+	enc, err := kr.Encrypt(plaintext, opts...)
+	if err != nil {
+		return false, err
+	}
+	return true, rewriteFile(path, enc)
+}
+
+// DecryptFileWithKeyring decrypts the encrypted file src into dst with any
+// key of kr. dst is replaced atomically and readable only by its owner.
+func DecryptFileWithKeyring(src, dst string, kr *Keyring) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	plaintext, err := kr.Decrypt(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+	return writeFileAtomic(dst, plaintext, 0600)
+}
+
+// LoadEncryptedFileWithKeyring loads an encrypted config file with any key of kr.
+func (cm *ConfigManager) LoadEncryptedFileWithKeyring(path string, kr *Keyring) error {
+	return cm.Load(context.Background(), EncryptedFileSourceWithKeyring(path, kr))
+}
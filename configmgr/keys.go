@@ -0,0 +1,199 @@
+package configmgr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultKeyDelimiter separates the segments of a nested key path, e.g. "database.host".
+const DefaultKeyDelimiter = "."
+
+// SetKeyDelimiter changes the separator used for nested key paths.
+// An empty delimiter restores DefaultKeyDelimiter.
+func (cm *ConfigManager) SetKeyDelimiter(delimiter string) {
+	if delimiter == "" {
+		delimiter = DefaultKeyDelimiter
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.delimiter = delimiter
+}
+
+// splitKey turns a key path into normalized segments, dropping empty ones.
+// The caller must hold cm.mu.
+func (cm *ConfigManager) splitKey(key string) []string {
+	return splitKeyPath(key, cm.delimiter)
+}
+
+// keyPath is splitKey for reading a key: when the nested path is not set but
+// a flat key with the delimiter in its name is, e.g. "feature.flags" in a
+// JSON file, the path of that key is returned. The caller must hold cm.mu.
+func (cm *ConfigManager) keyPath(key string) []string {
+	path := cm.splitKey(key)
+	if found, ok := findPath(cm.raw, path, cm.delimiter); ok {
+		return found
+	}
+	return path
+}
+
+// keyTree builds a normalized tree from flat key paths, e.g. env variables.
+func (cm *ConfigManager) keyTree(flat map[string]string) map[string]interface{} {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	tree := make(map[string]interface{}, len(flat))
+	for k, v := range flat {
+		setPath(tree, cm.splitKey(k), normalizeValue(v))
+	}
+	return tree
+}
+
+func splitKeyPath(key, delimiter string) []string {
+	parts := strings.Split(normalizeKey(key), delimiter)
+	segments := parts[:0]
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			segments = append(segments, p)
+		}
+	}
+	return segments
+}
+
+// lookupPath walks the tree along path and returns the value found there.
+func lookupPath(tree map[string]interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	var cur interface{} = tree
+	for _, seg := range path {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[seg]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// findPath returns the path of the value at path in tree. Where the nested
+// walk fails, the remaining segments joined by delimiter are tried as a
+// single key, so flat dotted keys of files stay reachable.
+func findPath(tree map[string]interface{}, path []string, delimiter string) ([]string, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	if v, ok := tree[path[0]]; ok {
+		if len(path) == 1 {
+			return path, true
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			if rest, ok := findPath(sub, path[1:], delimiter); ok {
+				return append([]string{path[0]}, rest...), true
+			}
+		}
+	}
+	if len(path) > 1 {
+		k := strings.Join(path, delimiter)
+		if _, ok := tree[k]; ok {
+			return []string{k}, true
+		}
+	}
+	return nil, false
+}
+
+// setPath stores value at path, creating (or replacing) intermediate maps as needed.
+func setPath(tree map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 0 {
+		return
+	}
+	cur := tree
+	for _, seg := range path[:len(path)-1] {
+		next, ok := cur[seg].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[seg] = next
+		}
+		cur = next
+	}
+	cur[path[len(path)-1]] = value
+}
+
+// deletePath removes the value at path from tree, and the maps left empty above it.
+func deletePath(tree map[string]interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	if len(path) > 1 {
+		sub, ok := tree[path[0]].(map[string]interface{})
+		if !ok {
+			return
+		}
+		deletePath(sub, path[1:])
+		if len(sub) > 0 {
+			return
+		}
+	}
+	delete(tree, path[0])
+}
+
+// mergeMaps deep-merges src into dst. Nested maps are merged key by key,
+// any other value in src replaces the one in dst.
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				mergeMaps(dm, sm)
+				continue
+			}
+			dst[k] = copyMap(sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// copyMap returns a deep copy of a config tree.
+func copyMap(src map[string]interface{}) map[string]interface{} {
+	dst := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		dst[k] = copyValue(v)
+	}
+	return dst
+}
+
+func copyValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return copyMap(t)
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			out[i] = copyValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// normalizeTree normalizes every key and value of a freshly decoded map.
+// Keys are not split: a file key holding the delimiter, e.g. YAML
+// "app.name: x", stays the single segment "APP.NAME", so maps keyed by host
+// names or URLs keep their keys. keyPath finds such keys on lookup.
+func normalizeTree(src map[string]interface{}) map[string]interface{} {
+	dst := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		dst[normalizeKey(k)] = normalizeValue(v)
+	}
+	return dst
+}
+
+// stringKeyMap converts map[interface{}]interface{} (as produced by some YAML decoders) into a tree map.
+func stringKeyMap(src map[interface{}]interface{}) map[string]interface{} {
+	dst := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		dst[fmt.Sprint(k)] = v
+	}
+	return dst
+}
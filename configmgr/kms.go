@@ -0,0 +1,153 @@
+package configmgr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KMS wraps and unwraps data keys with a master key that never leaves the key
+// management service (envelope encryption). Implement it on top of a cloud
+// KMS client; LocalKMS is a file-backed implementation for tests and development.
+type KMS interface {
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// KMSKey returns the data key stored wrapped (base64) in wrappedKeyFile,
+// unwrapped with kms. The key is cached until the file changes, so a reload
+// after the key was rewrapped or replaced unwraps it again.
+//
+// A 32-byte data key, as created by GenerateDataKey, is random: files
+// encrypted with it use it as the AES key directly instead of running it
+// through the password KDF (see KDFNone). Files encrypted with it before are
+// still read.
+func KMSKey(kms KMS, wrappedKeyFile string) KeyProvider {
+	k := &kmsKey{kms: kms, path: wrappedKeyFile}
+	return KeyProviderFunc(func(ctx context.Context) (*Keyring, error) {
+		key, err := k.unwrap(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("key from KMS key %s: %w", wrappedKeyFile, err)
+		}
+		return NewKeyring(key)
+	})
+}
+
+type kmsKey struct {
+	kms  KMS
+	path string
+
+	mu      sync.Mutex
+	wrapped string // file contents the cached key was unwrapped from
+	key     Key
+}
+
+func (k *kmsKey) unwrap(ctx context.Context) (Key, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return Key{}, err
+	}
+	text := strings.TrimSpace(string(data))
+	if text == k.wrapped && k.key.Secret != "" {
+		return k.key, nil
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return Key{}, err
+	}
+	plain, err := k.kms.Decrypt(ctx, wrapped)
+	if err != nil {
+		return Key{}, err
+	}
+	if len(plain) == 0 {
+		return Key{}, errors.New("key is empty")
+	}
+	k.wrapped = text
+	k.key = Key{Secret: base64.StdEncoding.EncodeToString(plain), dataKey: len(plain) == keySize}
+	return k.key, nil
+}
+
+// GenerateDataKey creates a random data key, wraps it with kms and writes it
+// (base64) to wrappedKeyFile for KMSKey. The plaintext key is never stored.
+func GenerateDataKey(ctx context.Context, kms KMS, wrappedKeyFile string) error {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, err := kms.Encrypt(ctx, key)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(wrappedKeyFile, []byte(base64.StdEncoding.EncodeToString(wrapped)+"\n"), 0600)
+}
+
+// LocalKMS is a KMS whose master key is a local file. It stands in for a real
+// key management service in tests and development; it offers no protection
+// beyond the permissions of the file.
+type LocalKMS struct {
+	key []byte
+}
+
+// NewLocalKMS returns a LocalKMS with the master key (base64, 32 bytes) in
+// path, creating the file with a random key if it does not exist.
+func NewLocalKMS(path string) (*LocalKMS, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		key := make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return &LocalKMS{key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("local KMS key %s: %w", path, err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("local KMS key %s: want %d bytes, got %d", path, keySize, len(key))
+	}
+	return &LocalKMS{key: key}, nil
+}
+
+// Encrypt wraps plaintext with the master key (AES-256-GCM, nonce prefixed).
+func (k *LocalKMS) Encrypt(_ context.Context, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt unwraps a ciphertext produced by Encrypt.
+func (k *LocalKMS) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+	return plaintext, nil
+}
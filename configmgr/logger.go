@@ -6,6 +6,27 @@ type Logger interface {
 	Error(msg string, err error, fields map[string]interface{})
 }
 
+// SetLogger sets the logger used by loaders. A nil logger disables logging.
 func (cm *ConfigManager) SetLogger(l Logger) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
 	cm.logger = l
 }
+
+func (cm *ConfigManager) logInfo(msg string, fields map[string]interface{}) {
+	cm.mu.RLock()
+	l := cm.logger
+	cm.mu.RUnlock()
+	if l != nil {
+		l.Info(msg, fields)
+	}
+}
+
+func (cm *ConfigManager) logError(msg string, err error, fields map[string]interface{}) {
+	cm.mu.RLock()
+	l := cm.logger
+	cm.mu.RUnlock()
+	if l != nil {
+		l.Error(msg, err, fields)
+	}
+}
@@ -1,68 +1,299 @@
 package configmgr
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
-// LoadWithProfile loads a base config file and, if available, a profile-specific file
-// based on the value of a given environment variable.
+// LoadWithProfile loads a base config file and, if available, the files of the
+// profiles listed, comma-separated, in the environment variable envKey.
 //
-// Supported file types: .json, .yaml, .yml, .env
+// Supported file types: .json, .yaml, .yml, .toml, .env
 //
 // Behavior:
 //   - If envKey is not set, only the baseFile is loaded.
 //   - If envKey=dev and baseFile=config.yaml, then config.yaml + config-dev.yaml are loaded.
 //   - If envKey=prod and baseFile=config.json, then config.json + config-prod.json are loaded.
+//   - If envKey=dev and baseFile=config.toml, then config.toml + config-dev.toml are loaded.
 //   - If envKey=dev and baseFile=.env, then .env + .env.dev are loaded.
-//   - If profile-specific file does not exist, only the baseFile is used.
+//   - If envKey=prod,eu-west,canary, the profile files are layered in that order:
+//     config.yaml + config-prod.yaml + config-eu-west.yaml + config-canary.yaml.
+//   - A profile file with a top-level "extends: staging" (EXTENDS=staging in .env
+//     files) is layered on top of the staging profile, which is loaded first.
+//     Several parents are given as a list or comma-separated. Every profile is
+//     loaded once; a cycle is an error. The extends key is not part of the config.
+//   - If profile-specific file does not exist, it is skipped, unless RequireProfiles
+//     is given. It is still watched by Watch, so creating it later triggers a reload.
+//     Profiles named by extends must exist.
+//   - With LocalOverride, config.local.yaml (.env.local for .env) is loaded last, if it exists.
 //
 // Example:
 //
 //	os.Setenv("APP_ENV", "dev")
 //	cm.LoadWithProfile("APP_ENV", "config.yaml") // loads config.yaml + config-dev.yaml
 //	cm.LoadWithProfile("APP_ENV", ".env")        // loads .env + .env.dev
-func (cm *ConfigManager) LoadWithProfile(envKey, baseFile string) error {
-	ext := strings.ToLower(filepath.Ext(baseFile))
+func (cm *ConfigManager) LoadWithProfile(envKey, baseFile string, opts ...ProfileOption) error {
+	if err := cm.Load(context.Background(), ProfileSource(envKey, baseFile, opts...)); err != nil {
+		return err
+	}
+	cm.logInfo("load_with_profile_success", map[string]interface{}{"path": baseFile, "profile": os.Getenv(envKey)})
+	return nil
+}
 
-	// determine profile (dev, staging, prod, etc.)
-	env := os.Getenv(envKey)
-	if env == "" {
-		if ext == ".env" {
-			return cm.LoadFromDotEnv(baseFile)
+// LoadWithProfileFS is LoadWithProfile for files of fsys.
+func (cm *ConfigManager) LoadWithProfileFS(fsys fs.FS, envKey, baseFile string, opts ...ProfileOption) error {
+	if err := cm.Load(context.Background(), ProfileSourceFS(fsys, envKey, baseFile, opts...)); err != nil {
+		return err
+	}
+	cm.logInfo("load_with_profile_success", map[string]interface{}{"path": baseFile, "profile": os.Getenv(envKey)})
+	return nil
+}
+
+// ProfileOption configures LoadWithProfile and ProfileSource.
+type ProfileOption func(*profileSource)
+
+// RequireProfiles makes a missing profile file an error instead of skipping it.
+func RequireProfiles() ProfileOption {
+	return func(s *profileSource) { s.required = true }
+}
+
+// LocalOverride adds an optional local override file on top of the profiles:
+// config.local.yaml for config.yaml, .env.local for .env. It is meant for
+// personal settings and should be kept out of version control.
+func LocalOverride() ProfileOption {
+	return func(s *profileSource) { s.local = true }
+}
+
+// ProfileSource returns a Source for a base file plus the profile files selected
+// by the environment variable envKey, as described for LoadWithProfile.
+// When loaded through Load, base and every profile file are separate layers.
+// The profiles and their extends keys are read when the source is loaded;
+// Reload re-reads the same files.
+func ProfileSource(envKey, baseFile string, opts ...ProfileOption) Source {
+	return ProfileSourceFS(nil, envKey, baseFile, opts...)
+}
+
+// ProfileSourceFS is ProfileSource for files of fsys. Files of an fs.FS are not watched.
+func ProfileSourceFS(fsys fs.FS, envKey, baseFile string, opts ...ProfileOption) Source {
+	s := &profileSource{fsys: fsys, envKey: envKey, baseFile: baseFile}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type profileSource struct {
+	fsys     fs.FS // nil for the OS file system
+	envKey   string
+	baseFile string
+	required bool
+	local    bool
+}
+
+func (s *profileSource) Name() string { return "profile:" + s.baseFile }
+
+func (s *profileSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return mergeSources(ctx, s)
+}
+
+func (s *profileSource) Paths() []string {
+	var paths []string
+	children, _ := s.expand()
+	for _, child := range children {
+		if c, ok := child.(*configuredSource); ok {
+			child = c.Source
+		}
+		if w, ok := child.(WatchableSource); ok {
+			paths = append(paths, w.Paths()...)
 		}
-		return cm.LoadFromFile(baseFile)
 	}
+	return paths
+}
+
+func (s *profileSource) expand() ([]Source, error) {
+	ext := strings.ToLower(filepath.Ext(s.baseFile))
 
+	var base Source
 	switch ext {
-	case ".json", ".yaml", ".yml":
-		// base
-		if err := cm.LoadFromFile(baseFile); err != nil {
-			return err
+	case ".json", ".yaml", ".yml", ".toml":
+		base = &fileSource{fsys: s.fsys, path: s.baseFile}
+	case ".env":
+		base = &dotEnvSource{fsys: s.fsys, path: s.baseFile}
+	default:
+		return nil, fmt.Errorf("unsupported file type: %s", ext)
+	}
+
+	// determine profiles (dev, staging, prod, etc.)
+	r := &profileResolver{src: s, ext: ext, added: make(map[string]bool)}
+	for _, name := range splitProfiles(os.Getenv(s.envKey)) {
+		if err := r.add(name, s.required, nil); err != nil {
+			return nil, err
 		}
-		// profile
-		name := strings.TrimSuffix(baseFile, ext)
-		profileFile := fmt.Sprintf("%s-%s%s", name, env, ext)
-		if _, err := os.Stat(profileFile); err == nil {
-			return cm.LoadFromFile(profileFile)
+	}
+	sources := append([]Source{base}, r.sources...)
+
+	if s.local {
+		if ext == ".env" {
+			sources = append(sources, Configure(&dotEnvSource{fsys: s.fsys, path: s.baseFile + ".local"}, Optional()))
+		} else {
+			local := strings.TrimSuffix(s.baseFile, ext) + ".local" + ext
+			sources = append(sources, Configure(&fileSource{fsys: s.fsys, path: local}, Optional()))
 		}
+	}
+	return sources, nil
+}
+
+// profileFile returns the file of the named profile.
+func (s *profileSource) profileFile(ext, name string) string {
+	if ext == ".env" {
+		return fmt.Sprintf("%s.%s", s.baseFile, name) // e.g. .env.dev
+	}
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(s.baseFile, ext), name, ext)
+}
+
+// profileResolver orders profile files after the profiles they extend.
+type profileResolver struct {
+	src     *profileSource
+	ext     string
+	added   map[string]bool
+	sources []Source
+}
+
+// add appends the sources of profile name, after those of its parents.
+// chain holds the profiles extending name, for cycle detection.
+func (r *profileResolver) add(name string, required bool, chain []string) error {
+	for i, c := range chain {
+		if c == name {
+			return fmt.Errorf("profile cycle: %s -> %s", strings.Join(chain[i:], " -> "), name)
+		}
+	}
+	if r.added[name] {
 		return nil
+	}
+	path := r.src.profileFile(r.ext, name)
+	parents, err := profileExtends(r.src.fsys, path, r.ext)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	chain = append(chain, name)
+	for _, parent := range parents {
+		if err := r.add(parent, true, chain); err != nil {
+			return err
+		}
+	}
+	r.added[name] = true
+
+	var file profileFileSource
+	if r.ext == ".env" {
+		file.file = &dotEnvSource{fsys: r.src.fsys, path: path}
+	} else {
+		file.file = &fileSource{fsys: r.src.fsys, path: path}
+	}
+	var src Source = &file
+	if !required {
+		src = Configure(src, Optional())
+	}
+	r.sources = append(r.sources, src)
+	return nil
+}
+
+// extendsKey names the parent profiles in a profile file.
+const extendsKey = "EXTENDS"
+
+// profileExtends reads the profiles a profile file extends. A missing file
+// extends nothing. Values are not decrypted.
+func profileExtends(fsys fs.FS, path, ext string) ([]string, error) {
+	raw, err := readFile(fsys, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
 
+	doc := make(map[string]interface{})
+	switch ext {
+	case ".toml":
+		err = toml.Unmarshal(raw, &doc)
 	case ".env":
-		// base
-		if err := cm.LoadFromDotEnv(baseFile); err != nil {
-			return err
+		var env map[string]string
+		env, err = godotenv.UnmarshalBytes(raw)
+		for k, v := range env {
+			doc[k] = v
 		}
-		// profile
-		profileFile := fmt.Sprintf("%s.%s", baseFile, env) // e.g. .env.dev
-		if _, err := os.Stat(profileFile); err == nil {
-			return cm.LoadFromDotEnv(profileFile)
+	default:
+		err = yaml.Unmarshal(raw, &doc) // JSON is YAML
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range doc {
+		if normalizeKey(k) != extendsKey {
+			continue
 		}
-		return nil
+		if s, ok := v.(string); ok {
+			return splitProfiles(s), nil
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a profile name or a list of names, got %T", k, v)
+		}
+		var names []string
+		for _, item := range items {
+			s, err := toString(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			names = append(names, splitProfiles(s)...)
+		}
+		return names, nil
+	}
+	return nil, nil
+}
 
-	default:
-		return fmt.Errorf("unsupported file type: %s", ext)
+// splitProfiles splits a comma-separated list of profile names.
+func splitProfiles(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// profileFileSource is the source of a profile file: a file or .env source whose
+// extends key is not part of the config.
+type profileFileSource struct {
+	file interface {
+		WatchableSource
+		locatedSource
+		describedSource
+	}
+}
+
+func (s *profileFileSource) Name() string                { return s.file.Name() }
+func (s *profileFileSource) Paths() []string             { return s.file.Paths() }
+func (s *profileFileSource) origin() (kind, path string) { return s.file.origin() }
+
+func (s *profileFileSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *profileFileSource) load(ctx context.Context, opts loadOptions) (loaded, error) {
+	res, err := s.file.load(ctx, opts)
+	if err == nil {
+		delete(res.tree, extendsKey)
 	}
+	return res, err
 }
@@ -0,0 +1,334 @@
+package configmgr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Origin describes one value assigned to a key by one source.
+type Origin struct {
+	Kind  string      // loader kind: "file", "dotenv", "sysenv", "encrypted", "set"
+	Path  string      // file path, or the variable name for "sysenv"
+	Line  int         // 1-based line in Path, 0 when unknown
+	Value interface{} // value as set by this source, a Secret for secret values
+
+	secret bool // the value was encrypted
+}
+
+func (o Origin) String() string {
+	switch {
+	case o.Path == "":
+		return o.Kind
+	case o.Line > 0:
+		return fmt.Sprintf("%s %s:%d", o.Kind, o.Path, o.Line)
+	default:
+		return fmt.Sprintf("%s %s", o.Kind, o.Path)
+	}
+}
+
+// Provenance explains the final value of a key: the source that set it and
+// the earlier values it overrode, oldest first (at most the last 16).
+type Provenance struct {
+	Key        string
+	Origin     Origin
+	Overridden []Origin
+}
+
+func (p Provenance) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s = %v (from %s)", p.Key, p.Origin.Value, p.Origin)
+	for i := len(p.Overridden) - 1; i >= 0; i-- {
+		o := p.Overridden[i]
+		fmt.Fprintf(&b, "\n  overrides %v (from %s)", o.Value, o)
+	}
+	return b.String()
+}
+
+// SourceInfo describes one loaded source and the keys it set.
+type SourceInfo struct {
+	Kind string
+	Path string
+	Keys []string
+}
+
+// Explain reports where the current value of key came from.
+// It returns false when the key is not set or is not a leaf value.
+// With AutomaticEnv, a matching environment variable is reported as the final origin.
+func (cm *ConfigManager) Explain(key string) (Provenance, bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.explain(cm.keyPath(key))
+}
+
+// explain is Explain for a split key path. The caller must hold cm.mu.
+func (cm *ConfigManager) explain(path []string) (Provenance, bool) {
+	p, ok := cm.provenanceOf(path)
+	if !ok {
+		return p, false
+	}
+	return cm.maskOrigins(p, path), true
+}
+
+// provenanceOf is explain without masking secret values. The caller must hold cm.mu.
+func (cm *ConfigManager) provenanceOf(path []string) (Provenance, bool) {
+	p, ok := cm.provenance[pathKey(path)]
+	var out Provenance
+	if ok {
+		out = *p
+		out.Overridden = append([]Origin(nil), p.Overridden...)
+	}
+	out.Key = cm.displayKey(pathKey(path))
+	if v, variable, set := cm.envOverrideVar(path); set {
+		if ok {
+			out.Overridden = append(out.Overridden, out.Origin)
+		}
+		out.Origin = Origin{Kind: "env", Path: variable, Value: v, secret: strings.HasSuffix(variable, envFileSuffix)}
+		return out, true
+	}
+	return out, ok
+}
+
+// Sources lists every loaded source in load order, with the keys it set.
+func (cm *ConfigManager) Sources() []SourceInfo {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	out := make([]SourceInfo, 0, len(cm.layers))
+	for _, l := range cm.layers {
+		info := SourceInfo{Kind: l.kind, Path: l.path}
+		for _, leaf := range leafPaths(l.tree, nil) {
+			info.Keys = append(info.Keys, cm.displayKey(pathKey(leaf)))
+		}
+		sort.Strings(info.Keys)
+		out = append(out, info)
+	}
+	return out
+}
+
+// pathSep joins key segments into provenance map keys. It cannot appear in a
+// key path, so the result does not depend on the configured delimiter.
+const pathSep = "\x00"
+
+func pathKey(segments []string) string {
+	return strings.Join(segments, pathSep)
+}
+
+// displayKey renders a provenance map key with the configured delimiter.
+// The caller must hold cm.mu.
+func (cm *ConfigManager) displayKey(k string) string {
+	return strings.ReplaceAll(k, pathSep, cm.delimiter)
+}
+
+// mergeLayer merges l.tree into cm.raw and records its provenance. The
+// caller must hold cm.mu.
+func (cm *ConfigManager) mergeLayer(l *layer) {
+	cm.recordProvenance(l)
+	mergeMaps(cm.raw, l.tree)
+}
+
+// recordProvenance records the leaves of l.tree as the newest origins. It
+// runs before l is merged, so cm.raw still holds the values l replaces.
+// The caller must hold cm.mu.
+func (cm *ConfigManager) recordProvenance(l *layer) {
+	for _, leaf := range leafPaths(l.tree, nil) {
+		k := pathKey(leaf)
+		// a leaf replaces the map it overwrites, and anything above it was a leaf replaced by a map
+		if old, ok := lookupPath(cm.raw, leaf); ok {
+			if sub, isMap := old.(map[string]interface{}); isMap {
+				for _, below := range leafPaths(sub, leaf) {
+					delete(cm.provenance, pathKey(below))
+				}
+			}
+		}
+		for i := 1; i < len(leaf); i++ {
+			delete(cm.provenance, pathKey(leaf[:i]))
+		}
+
+		v, _ := lookupPath(l.tree, leaf)
+		origin := Origin{Kind: l.kind, Path: l.path, Line: l.lines[k], Value: v,
+			secret: l.kind == "encrypted" || hasSecretBelow(l.secrets, k)}
+		p, ok := cm.provenance[k]
+		if !ok {
+			cm.provenance[k] = &Provenance{Key: k, Origin: origin}
+			continue
+		}
+		p.Overridden = append(p.Overridden, p.Origin)
+		if n := len(p.Overridden); n > maxOverridden {
+			p.Overridden = append(p.Overridden[:0], p.Overridden[n-maxOverridden:]...)
+		}
+		p.Origin = origin
+	}
+}
+
+// maxOverridden caps the overridden origins kept per key, e.g. for keys Set
+// over and over by a background refresher.
+const maxOverridden = 16
+
+// leafPaths returns the paths of all non-map values in tree, sorted.
+func leafPaths(tree map[string]interface{}, prefix []string) [][]string {
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out [][]string
+	for _, k := range keys {
+		p := append(append([]string(nil), prefix...), k)
+		if sub, ok := tree[k].(map[string]interface{}); ok && len(sub) > 0 {
+			out = append(out, leafPaths(sub, p)...)
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// yamlLines maps every key of a YAML document to the line it is defined on.
+func yamlLines(raw []byte) map[string]int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	lines := make(map[string]int)
+	var walk func(n *yaml.Node, prefix []string)
+	walk = func(n *yaml.Node, prefix []string) {
+		if n.Kind == yaml.AliasNode {
+			n = n.Alias
+		}
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := append(append([]string(nil), prefix...), normalizeKey(n.Content[i].Value))
+			lines[pathKey(p)] = n.Content[i].Line
+			walk(n.Content[i+1], p)
+		}
+	}
+	walk(doc.Content[0], nil)
+	return lines
+}
+
+// jsonLines maps every object key of a JSON document to the line it is defined on.
+func jsonLines(raw []byte) map[string]int {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	lines := make(map[string]int)
+
+	type frame struct {
+		object    bool
+		expectKey bool
+		path      []string
+	}
+	var stack []frame
+	var pending []string // path of the value about to be read
+	lineAt := func(offset int64) int {
+		return bytes.Count(raw[:offset], []byte("\n")) + 1
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return lines
+		}
+		var top *frame
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		if top != nil && top.object && top.expectKey {
+			if key, ok := tok.(string); ok {
+				pending = append(append([]string(nil), top.path...), normalizeKey(key))
+				lines[pathKey(pending)] = lineAt(dec.InputOffset())
+				top.expectKey = false
+				continue
+			}
+		}
+
+		switch tok {
+		case json.Delim('{'):
+			stack = append(stack, frame{object: true, expectKey: true, path: pending})
+		case json.Delim('['):
+			stack = append(stack, frame{path: pending})
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+		}
+		// after a complete value inside an object, the next token is a key
+		if len(stack) > 0 && tok != json.Delim('{') && tok != json.Delim('[') {
+			if top := &stack[len(stack)-1]; top.object {
+				top.expectKey = true
+			}
+		}
+	}
+}
+
+// tomlLines maps every key of a TOML document to the line it is defined on.
+// The decoder reports keys in document order, so each key is searched from
+// the line of the previous one.
+func tomlLines(raw []byte, md toml.MetaData) map[string]int {
+	src := strings.Split(string(raw), "\n")
+	lines := make(map[string]int)
+	cur := 0
+	for _, key := range md.Keys() {
+		for i := cur; i < len(src); i++ {
+			if tomlDefines(src[i], key[len(key)-1]) {
+				p := make([]string, len(key))
+				for j, seg := range key {
+					p[j] = normalizeKey(seg)
+				}
+				lines[pathKey(p)] = i + 1
+				cur = i
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// tomlDefines reports whether a line is a table header or key/value pair naming key.
+func tomlDefines(line, key string) bool {
+	line = strings.TrimSpace(line)
+	var name string
+	if strings.HasPrefix(line, "[") {
+		end := strings.Index(line, "]")
+		if end < 0 {
+			return false
+		}
+		name = strings.Trim(line[:end], "[")
+	} else {
+		var ok bool
+		if name, _, ok = strings.Cut(line, "="); !ok || strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	for _, seg := range strings.Split(name, ".") {
+		if strings.Trim(strings.TrimSpace(seg), `"'`) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// dotenvLines maps every variable of a .env file to the line it is defined on.
+// Later definitions win, as they do in godotenv.
+func dotenvLines(raw []byte, delimiter string) map[string]int {
+	lines := make(map[string]int)
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			continue
+		}
+		lines[pathKey(splitKeyPath(strings.TrimSpace(line[:i]), delimiter))] = n
+	}
+	return lines
+}
@@ -0,0 +1,104 @@
+package configmgr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// LoadFromReader reads a whole config document from r, e.g. stdin or an HTTP
+// response body, and loads it like LoadFromBytes.
+func (cm *ConfigManager) LoadFromReader(r io.Reader, format string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return cm.LoadFromBytes(data, format)
+}
+
+// LoadFromBytes loads a config document held in memory. format is "json",
+// "yaml" ("yml"), "toml" or "env" ("dotenv"); an empty format is detected
+// from the content. Pass the format when it is known: a document such as
+// "PORT=8080" is valid TOML and .env alike.
+func (cm *ConfigManager) LoadFromBytes(data []byte, format string) error {
+	if err := cm.Load(context.Background(), BytesSource(data, format)); err != nil {
+		return err
+	}
+	cm.logInfo("load_from_bytes_success", map[string]interface{}{"format": format})
+	return nil
+}
+
+// BytesSource returns a Source decoding data in the given format, as described
+// for LoadFromBytes. The data is copied; Reload yields the same values.
+func BytesSource(data []byte, format string) Source {
+	return &bytesSource{data: bytes.Clone(data), format: strings.ToLower(format)}
+}
+
+type bytesSource struct {
+	data   []byte
+	format string
+}
+
+func (s *bytesSource) Name() string                { return "bytes:" + s.format }
+func (s *bytesSource) origin() (kind, path string) { return "bytes", "" }
+
+func (s *bytesSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return loadTree(ctx, s)
+}
+
+func (s *bytesSource) load(ctx context.Context, opts loadOptions) (loaded, error) {
+	data := bytes.TrimPrefix(s.data, utf8BOM)
+	format := s.format
+	if format == "" {
+		format = sniffFormat(data)
+	}
+	switch format {
+	case "json", "yaml", "yml", "toml":
+		return decodeConfig(ctx, data, "."+format, opts.keys)
+	case "env", "dotenv":
+		return decodeDotEnv(data, opts.delimiter)
+	default:
+		return loaded{}, fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// sniffFormat guesses the format of a config document from its first
+// significant line: "{" is JSON; a "[table]" header or "key = value" is TOML
+// when the document parses as TOML; other "KEY=value" lines are .env; the
+// rest, including "key: value", is YAML.
+func sniffFormat(data []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "{"):
+			return "json"
+		case strings.HasPrefix(line, "---"):
+			return "yaml"
+		case strings.HasPrefix(line, "export "):
+			return "env"
+		}
+		eq, colon := strings.Index(line, "="), strings.Index(line, ":")
+		if strings.HasPrefix(line, "[") || (eq > 0 && (colon < 0 || eq < colon)) {
+			var v map[string]interface{}
+			if _, err := toml.Decode(string(data), &v); err == nil {
+				return "toml"
+			}
+			if eq > 0 {
+				return "env"
+			}
+		}
+		return "yaml"
+	}
+	return "yaml"
+}
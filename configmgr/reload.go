@@ -0,0 +1,235 @@
+package configmgr
+
+import (
+	"context"
+	"reflect"
+)
+
+// layer is one entry of the source stack. Every loaded source is recorded as
+// a layer so the whole stack can be rebuilt, in its original order, when a
+// watched file changes. Layers are kept sorted by priority.
+type layer struct {
+	// src re-reads the layer. A nil src marks a static layer (e.g. Set)
+	// that always yields tree.
+	src      Source
+	kind     string   // loader kind, e.g. "file", "dotenv", "sysenv", "set"
+	path     string   // file path or variable name reported by Explain
+	paths    []string // files backing this layer, used by Watch
+	priority int
+	optional bool
+	policy   ErrorPolicy
+	group    *layerGroup // the composite source the layer was expanded from, if any
+
+	tree    map[string]interface{}
+	lines   map[string]int
+	secrets map[string]bool
+}
+
+// layerGroup is a composite source, such as DirSource, expanded into layers.
+// Reload expands it again, so files added to a directory are picked up.
+type layerGroup struct {
+	src   Source
+	cfg   sourceConfig
+	paths []string // watched in addition to the files of its layers, e.g. the directory
+}
+
+// loaded is what a layer load produces: a normalized tree plus, when known,
+// the 1-based line of each key and the keys of encrypted values, indexed by pathKey.
+type loaded struct {
+	tree    map[string]interface{}
+	lines   map[string]int
+	secrets map[string]bool
+}
+
+// Snapshot is an immutable view of the configuration at one point in time.
+type Snapshot struct {
+	data      map[string]interface{}
+	delimiter string
+}
+
+// snapshot returns the current configuration with secret values masked.
+// The data is a private copy, so callbacks can never observe later mutations.
+// The caller must hold cm.mu.
+func (cm *ConfigManager) snapshot() Snapshot {
+	return Snapshot{data: cm.mask(cm.data, nil).(map[string]interface{}), delimiter: cm.delimiter}
+}
+
+// Get returns a raw value from the snapshot, secret values as Secret. Key paths work as in ConfigManager.Get.
+func (s Snapshot) Get(key string) interface{} {
+	path := splitKeyPath(key, s.delimiter)
+	if found, ok := findPath(s.data, path, s.delimiter); ok {
+		path = found
+	}
+	v, _ := lookupPath(s.data, path)
+	return v
+}
+
+// All returns a deep copy of the snapshot data.
+func (s Snapshot) All() map[string]interface{} {
+	return copyMap(s.data)
+}
+
+// OnChange registers a callback invoked after a reload changed the configuration.
+// Callbacks run synchronously on the reloading goroutine, in registration order.
+func (cm *ConfigManager) OnChange(fn func(old, new Snapshot)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.onChange = append(cm.onChange, fn)
+}
+
+// Reload rebuilds the configuration by re-reading every source in its original order.
+// If any source fails, the current configuration is kept and the error is returned.
+// OnChange callbacks are invoked when the resulting configuration differs.
+func (cm *ConfigManager) Reload() error {
+	return cm.reload(context.Background())
+}
+
+func (cm *ConfigManager) reload(ctx context.Context) error {
+	cm.reloadMu.Lock()
+	defer cm.reloadMu.Unlock()
+
+	cm.mu.RLock()
+	layers := append([]*layer(nil), cm.layers...)
+	opts := cm.loadOptions()
+	cm.mu.RUnlock()
+
+	results := make(map[*layer]loaded, len(layers))
+	expanded := make(map[*layerGroup][]*layer)
+	for _, l := range layers {
+		if g := l.group; g != nil {
+			if _, done := expanded[g]; done {
+				continue
+			}
+			ls, err := cm.reexpand(ctx, g, layers, opts)
+			if err != nil {
+				if g.cfg.policy != SkipOnError {
+					return err
+				}
+				cm.logError("reload_source_skipped", err, map[string]interface{}{"source": g.src.Name()})
+				expanded[g] = nil // keeps its layers
+				continue
+			}
+			expanded[g] = ls
+			continue
+		}
+		if l.src == nil {
+			continue
+		}
+		res, err := l.fetch(ctx, opts)
+		if err != nil {
+			if l.policy != SkipOnError {
+				return err
+			}
+			cm.logError("reload_source_skipped", err, map[string]interface{}{"source": l.src.Name()})
+			continue
+		}
+		results[l] = res
+	}
+
+	cm.mu.Lock()
+	old := cm.snapshot()
+	// layers added while reloading keep the tree they were loaded with
+	prev := make(map[*layer]loaded, len(results))
+	for l, res := range results {
+		prev[l] = loaded{tree: l.tree, lines: l.lines, secrets: l.secrets}
+		l.tree, l.lines, l.secrets = res.tree, res.lines, res.secrets
+	}
+	prevLayers := cm.layers
+	cm.layers = replaceGroups(cm.layers, expanded)
+	if err := cm.rebuild(); err != nil {
+		// keep the last good configuration
+		for l, res := range prev {
+			l.tree, l.lines, l.secrets = res.tree, res.lines, res.secrets
+		}
+		cm.layers = prevLayers
+		_ = cm.rebuild()
+		cm.mu.Unlock()
+		return err
+	}
+	next := cm.snapshot()
+	subscribers := make([]func(old, new Snapshot), len(cm.onChange))
+	copy(subscribers, cm.onChange)
+	cm.mu.Unlock()
+
+	cm.logInfo("config_reloaded", map[string]interface{}{"sources": len(layers)})
+	if reflect.DeepEqual(old.data, next.data) {
+		return nil
+	}
+	for _, fn := range subscribers {
+		fn(old, next)
+	}
+	return nil
+}
+
+// reexpand expands the composite source g again and loads its layers. A
+// layer failing with SkipOnError keeps the values of the layer it replaces.
+func (cm *ConfigManager) reexpand(ctx context.Context, g *layerGroup, current []*layer, opts loadOptions) ([]*layer, error) {
+	layers, err := flattenSource(g.src, g.cfg)
+	if err != nil {
+		return nil, err
+	}
+	prev := make(map[string]*layer)
+	for _, l := range current {
+		if l.group == g && l.src != nil {
+			prev[l.src.Name()] = l
+		}
+	}
+	for _, l := range layers {
+		if l.src == nil {
+			continue
+		}
+		res, err := l.fetch(ctx, opts)
+		if err != nil {
+			if l.policy != SkipOnError {
+				return nil, err
+			}
+			cm.logError("reload_source_skipped", err, map[string]interface{}{"source": l.src.Name()})
+			res = loaded{tree: map[string]interface{}{}}
+			if p, ok := prev[l.src.Name()]; ok {
+				res = loaded{tree: p.tree, lines: p.lines, secrets: p.secrets}
+			}
+		}
+		l.tree, l.lines, l.secrets = res.tree, res.lines, res.secrets
+	}
+	return layers, nil
+}
+
+// replaceGroups replaces the layers of every group with new layers in
+// expanded, at the position of its first layer.
+func replaceGroups(layers []*layer, expanded map[*layerGroup][]*layer) []*layer {
+	out := make([]*layer, 0, len(layers))
+	done := make(map[*layerGroup]bool)
+	for _, l := range layers {
+		ls, ok := expanded[l.group]
+		if l.group == nil || !ok || ls == nil {
+			out = append(out, l)
+			continue
+		}
+		if !done[l.group] {
+			done[l.group] = true
+			out = append(out, ls...)
+		}
+	}
+	return out
+}
+
+// watchedPaths returns the files backing the current source stack.
+func (cm *ConfigManager) watchedPaths() []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	seen := make(map[string]bool)
+	var paths []string
+	for _, l := range cm.layers {
+		layerPaths := l.paths
+		if l.group != nil {
+			layerPaths = append(append([]string(nil), l.group.paths...), layerPaths...)
+		}
+		for _, p := range layerPaths {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
@@ -0,0 +1,575 @@
+package configmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaDraft is the JSON Schema dialect written by GenerateSchema.
+const SchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema used to validate a configuration.
+//
+// Property names match config keys case-insensitively. Type checks are as
+// lenient as the getters: "8080" is a valid integer and "a,b" a valid array.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 SchemaType         `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+}
+
+// SchemaType lists the allowed JSON types of a value. In JSON it is either a
+// single type name or a list of them.
+type SchemaType []string
+
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *SchemaType) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*t = SchemaType{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("schema type must be a string or a list of strings")
+	}
+	*t = many
+	return nil
+}
+
+// ParseSchema parses a JSON schema document.
+func ParseSchema(raw []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.check(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// LoadSchemaFile reads and parses a JSON schema file.
+func LoadSchemaFile(path string) (*Schema, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSchema(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// check reports patterns that do not compile.
+func (s *Schema) check(path string) error {
+	if s.Pattern != "" {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("invalid schema pattern at %q: %w", path, err)
+		}
+	}
+	for name, p := range s.Properties {
+		if p == nil {
+			continue
+		}
+		if err := p.check(path + "/" + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "/items")
+	}
+	return nil
+}
+
+// ValidateSchema validates the current configuration against s and returns
+// every violation found, sorted by key, with the schema keyword that failed
+// (e.g. "required", "type", "minimum") as the rule and the source of the value.
+// An empty result means the config is valid.
+func (cm *ConfigManager) ValidateSchema(s *Schema) []FieldError {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	v := &schemaValidator{delimiter: cm.delimiter, secret: cm.isSecret, locate: cm.locate}
+	v.validate(s, cm.view(), nil)
+	sort.SliceStable(v.errs, func(i, j int) bool { return v.errs[i].Key < v.errs[j].Key })
+	return v.errs
+}
+
+// CheckRequired reports every key that is not set.
+func (cm *ConfigManager) CheckRequired(keys ...string) []FieldError {
+	var errs []FieldError
+	for _, k := range keys {
+		if _, ok := cm.lookup(k); !ok {
+			cm.mu.RLock()
+			key := strings.Join(cm.splitKey(k), cm.delimiter)
+			cm.mu.RUnlock()
+			errs = append(errs, FieldError{Key: key, Rule: "required", Message: "required key is missing"})
+		}
+	}
+	return errs
+}
+
+type schemaValidator struct {
+	delimiter string
+	secret    func(path []string) bool // values not to quote in messages
+	locate    func(fe *FieldError, path []string) bool
+	errs      []FieldError
+}
+
+// shown returns value for a message, redacted when it is secret.
+func (v *schemaValidator) shown(path []string, value interface{}) interface{} {
+	if v.secret != nil && v.secret(path) {
+		return Secret{value: value}
+	}
+	return value
+}
+
+func (v *schemaValidator) fail(path []string, rule, format string, args ...interface{}) {
+	fe := FieldError{
+		Key:     strings.Join(path, v.delimiter),
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+	}
+	if v.locate != nil {
+		v.locate(&fe, path)
+	}
+	v.errs = append(v.errs, fe)
+}
+
+func (v *schemaValidator) validate(s *Schema, value interface{}, path []string) {
+	if s == nil {
+		return
+	}
+	if len(s.Type) > 0 && !matchesAnyType(value, s.Type) {
+		got := jsonTypeOf(value)
+		if _, ok := value.(string); ok && v.secret != nil && v.secret(path) {
+			got = "string"
+		}
+		v.fail(path, "type", "expected %s, got %s", strings.Join(s.Type, " or "), got)
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		v.fail(path, "enum", "value %v is not one of %s", v.shown(path, value), formatEnum(s.Enum))
+	}
+
+	switch t := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		v.validateObject(s, t, path)
+	case []interface{}:
+		v.validateArray(s, t, path)
+	case string:
+		if hasType(s.Type, "array") && !hasType(s.Type, "string") {
+			items, _ := toItems(t)
+			v.validateArray(s, items, path)
+			return
+		}
+		v.validateString(s, t, path)
+		v.validateNumber(s, t, path)
+	case bool:
+	default:
+		// numbers are also checked in their string form (e.g. a port typed as string)
+		if str, err := toString(t); err == nil {
+			v.validateString(s, str, path)
+		}
+		v.validateNumber(s, t, path)
+	}
+}
+
+func (v *schemaValidator) validateObject(s *Schema, m map[string]interface{}, path []string) {
+	for _, name := range s.Required {
+		if _, ok := m[normalizeKey(name)]; !ok {
+			v.fail(appendPath(path, normalizeKey(name)), "required", "required key is missing")
+		}
+	}
+	known := make(map[string]bool, len(s.Properties))
+	for name, p := range s.Properties {
+		key := normalizeKey(name)
+		known[key] = true
+		if value, ok := m[key]; ok {
+			v.validate(p, value, appendPath(path, key))
+		}
+	}
+	if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+		for key := range m {
+			if !known[key] {
+				v.fail(appendPath(path, key), "additionalProperties", "key is not allowed by the schema")
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(s *Schema, items []interface{}, path []string) {
+	if s.MinItems != nil && len(items) < *s.MinItems {
+		v.fail(path, "minItems", "expected at least %d items, got %d", *s.MinItems, len(items))
+	}
+	if s.MaxItems != nil && len(items) > *s.MaxItems {
+		v.fail(path, "maxItems", "expected at most %d items, got %d", *s.MaxItems, len(items))
+	}
+	for i, item := range items {
+		v.validate(s.Items, item, appendPath(path, strconv.Itoa(i)))
+	}
+}
+
+func (v *schemaValidator) validateString(s *Schema, str string, path []string) {
+	n := len([]rune(str))
+	if s.MinLength != nil && n < *s.MinLength {
+		v.fail(path, "minLength", "expected at least %d characters, got %d", *s.MinLength, n)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		v.fail(path, "maxLength", "expected at most %d characters, got %d", *s.MaxLength, n)
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			v.fail(path, "pattern", "invalid pattern %q: %v", s.Pattern, err)
+		} else if !re.MatchString(str) {
+			v.fail(path, "pattern", "value does not match %q", s.Pattern)
+		}
+	}
+}
+
+func (v *schemaValidator) validateNumber(s *Schema, value interface{}, path []string) {
+	if s.Minimum == nil && s.Maximum == nil && s.ExclusiveMinimum == nil && s.ExclusiveMaximum == nil {
+		return
+	}
+	f, err := toFloat64(value)
+	if err != nil {
+		return // strings are only compared when they are numbers
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		v.fail(path, "minimum", "value %v is less than %v", v.shown(path, f), *s.Minimum)
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		v.fail(path, "maximum", "value %v is greater than %v", v.shown(path, f), *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+		v.fail(path, "exclusiveMinimum", "value %v must be greater than %v", v.shown(path, f), *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+		v.fail(path, "exclusiveMaximum", "value %v must be less than %v", v.shown(path, f), *s.ExclusiveMaximum)
+	}
+}
+
+func appendPath(path []string, seg string) []string {
+	out := make([]string, len(path), len(path)+1)
+	copy(out, path)
+	return append(out, seg)
+}
+
+func hasType(types SchemaType, want string) bool {
+	for _, t := range types {
+		if t == want {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyType(value interface{}, types SchemaType) bool {
+	for _, t := range types {
+		if matchesType(value, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesType checks value against a JSON type, converting leniently from strings.
+func matchesType(value interface{}, typ string) bool {
+	switch typ {
+	case "null":
+		return value == nil
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		switch value.(type) {
+		case []interface{}, string:
+			return true
+		}
+		return false
+	case "string":
+		return value != nil && isScalar(value)
+	case "boolean":
+		switch t := value.(type) {
+		case bool:
+			return true
+		case string:
+			_, err := toBool(t)
+			return err == nil
+		}
+		return false
+	case "integer":
+		if _, ok := value.(bool); ok || value == nil || !isScalar(value) {
+			return false
+		}
+		_, err := toInt64(value)
+		return err == nil
+	case "number":
+		if _, ok := value.(bool); ok || value == nil || !isScalar(value) {
+			return false
+		}
+		_, err := toFloat64(value)
+		return err == nil
+	default:
+		return false
+	}
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case bool:
+		return "boolean"
+	case string:
+		return fmt.Sprintf("string %q", value)
+	case int, int64, uint, uint64:
+		return "integer"
+	case float32, float64:
+		return "number"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// inEnum compares scalars by their string form, so 8080 matches "8080".
+func inEnum(value interface{}, enum []interface{}) bool {
+	s, err := toString(value)
+	if err != nil {
+		return false
+	}
+	for _, e := range enum {
+		if es, err := toString(e); err == nil && es == s {
+			return true
+		}
+	}
+	return false
+}
+
+func formatEnum(enum []interface{}) string {
+	parts := make([]string, len(enum))
+	for i, e := range enum {
+		parts[i] = fmt.Sprint(e)
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// GenerateSchema builds a JSON schema from a config struct, e.g. to validate
+// deploy configs in CI without the application binary.
+//
+// Properties follow the key names used by Unmarshal. The `validate` tags
+// required, min, max, gte, lte, gt, lt, len and oneof become schema rules
+// (required fields with a `default` tag are not required), `default` tags
+// become defaults and `desc` tags become descriptions.
+func GenerateSchema(v interface{}) (*Schema, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("schema source must be a struct, got %T", v)
+	}
+	s := schemaForType(t)
+	s.Schema = SchemaDraft
+	return s, nil
+}
+
+func schemaForType(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case durationType, timeType, urlType:
+		return &Schema{Type: SchemaType{"string"}}
+	case secretType:
+		return &Schema{}
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return &Schema{Type: SchemaType{"string"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		s := &Schema{Type: SchemaType{"object"}, Properties: map[string]*Schema{}}
+		addStructProperties(s, t)
+		return s
+	case reflect.Map:
+		return &Schema{Type: SchemaType{"object"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: SchemaType{"string"}}
+		}
+		return &Schema{Type: SchemaType{"array"}, Items: schemaForType(t.Elem())}
+	case reflect.String:
+		return &Schema{Type: SchemaType{"string"}}
+	case reflect.Bool:
+		return &Schema{Type: SchemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: SchemaType{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaType{"number"}}
+	default:
+		return &Schema{}
+	}
+}
+
+func addStructProperties(s *Schema, t reflect.Type) {
+	for _, fp := range planFor(t) {
+		f := t.Field(fp.index)
+		if fp.squash {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			addStructProperties(s, ft)
+			continue
+		}
+		p := schemaForType(f.Type)
+		p.Description = f.Tag.Get("desc")
+		def, hasDefault := f.Tag.Lookup("default")
+		if hasDefault {
+			p.Default = parseDefault(def)
+		}
+		if applyValidateTag(p, f.Tag.Get("validate")) && !hasDefault {
+			s.Required = append(s.Required, fp.label)
+		}
+		s.Properties[fp.label] = p
+	}
+}
+
+func parseDefault(def string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(def), &v); err != nil || v == nil {
+		return def
+	}
+	return v
+}
+
+// applyValidateTag translates validator rules into schema keywords and
+// reports whether the field is required. Rules after "dive" apply to
+// elements and are ignored.
+func applyValidateTag(s *Schema, tag string) (required bool) {
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "dive" {
+			break
+		}
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			for _, opt := range strings.Fields(param) {
+				s.Enum = append(s.Enum, typedParam(s, opt))
+			}
+		case "min", "gte", "gt":
+			setLowerBound(s, param, name == "gt")
+		case "max", "lte", "lt":
+			setUpperBound(s, param, name == "lt")
+		case "len":
+			setLowerBound(s, param, false)
+			setUpperBound(s, param, false)
+		}
+	}
+	return required
+}
+
+// typedParam converts an enum option to the field's type so the generated
+// schema reads naturally (oneof=1 2 becomes [1, 2] for integers).
+func typedParam(s *Schema, p string) interface{} {
+	if hasType(s.Type, "integer") || hasType(s.Type, "number") {
+		if f, err := strconv.ParseFloat(p, 64); err == nil {
+			return normalizeValue(f)
+		}
+	}
+	return p
+}
+
+func setLowerBound(s *Schema, param string, exclusive bool) {
+	f, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	switch {
+	case hasType(s.Type, "integer"), hasType(s.Type, "number"):
+		if exclusive {
+			s.ExclusiveMinimum = &f
+		} else {
+			s.Minimum = &f
+		}
+	case hasType(s.Type, "string"):
+		n := lengthBound(f, exclusive, 1)
+		s.MinLength = &n
+	case hasType(s.Type, "array"):
+		n := lengthBound(f, exclusive, 1)
+		s.MinItems = &n
+	}
+}
+
+func setUpperBound(s *Schema, param string, exclusive bool) {
+	f, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	switch {
+	case hasType(s.Type, "integer"), hasType(s.Type, "number"):
+		if exclusive {
+			s.ExclusiveMaximum = &f
+		} else {
+			s.Maximum = &f
+		}
+	case hasType(s.Type, "string"):
+		n := lengthBound(f, exclusive, -1)
+		s.MaxLength = &n
+	case hasType(s.Type, "array"):
+		n := lengthBound(f, exclusive, -1)
+		s.MaxItems = &n
+	}
+}
+
+// lengthBound turns a validator length parameter into an inclusive bound.
+func lengthBound(f float64, exclusive bool, step int) int {
+	n := int(f)
+	if exclusive {
+		n += step
+	}
+	return n
+}
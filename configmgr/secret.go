@@ -0,0 +1,283 @@
+package configmgr
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	pathpkg "path"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Secret holds a config value that must not leak. It prints, logs and
+// marshals (JSON, YAML, TOML, text) as "[REDACTED]"; Reveal returns the value.
+//
+// Get, GetAll, GetRaw, snapshots, exports, Explain and error messages return
+// secret values as Secret. A value is secret when its key matches a pattern
+// given to MarkSecret, when it was decoded into a struct field tagged
+// `secret:"true"` or of type Secret, when it was set with Set(key, NewSecret(v)),
+// or when it came from an encrypted file or an encrypted value. A value
+// interpolated from a secret is secret too.
+//
+// The typed getters (GetString, ...) refuse secret keys: their ...E variants
+// fail with ErrSecretKey, the others return the zero value or default. Read a
+// secret with Reveal; Unmarshal decodes the plain value into the target field.
+type Secret struct {
+	value interface{}
+}
+
+var secretType = reflect.TypeOf(Secret{})
+
+// NewSecret wraps v. Set(key, NewSecret(v)) stores v and marks key secret.
+func NewSecret(v interface{}) Secret {
+	return Secret{value: v}
+}
+
+// Reveal returns the secret value.
+func (s Secret) Reveal() interface{} { return s.value }
+
+// Reveal returns the value stored at key like Get, without wrapping secret
+// values in Secret. It is the explicit read of a secret key.
+func (cm *ConfigManager) Reveal(key string) interface{} {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	v, _ := cm.value(cm.keyPath(key))
+	return copyValue(v)
+}
+
+func (s Secret) String() string   { return redactedValue }
+func (s Secret) GoString() string { return "configmgr.Secret(" + redactedValue + ")" }
+
+// Format redacts the value for every verb, including %#v and %d.
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('#') {
+		fmt.Fprint(f, s.GoString())
+		return
+	}
+	fmt.Fprint(f, redactedValue)
+}
+
+func (s Secret) MarshalJSON() ([]byte, error)      { return json.Marshal(redactedValue) }
+func (s Secret) MarshalYAML() (interface{}, error) { return redactedValue, nil }
+func (s Secret) MarshalText() ([]byte, error)      { return []byte(redactedValue), nil }
+func (s Secret) LogValue() slog.Value              { return slog.StringValue(redactedValue) }
+
+// MarkSecret marks the keys matching the patterns as secret. A pattern is a
+// key path whose segments may use the wildcards of path.Match, e.g.
+// "database.password" or "servers.*.token"; it covers the keys below it too.
+// A pattern of a single segment, e.g. "*password*", matches a segment at any depth.
+func (cm *ConfigManager) MarkSecret(patterns ...string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	var add []secretPattern
+	for _, p := range patterns {
+		segs := cm.splitKey(p)
+		if len(segs) == 0 {
+			return fmt.Errorf("empty secret pattern %q", p)
+		}
+		for _, seg := range segs {
+			if _, err := pathpkg.Match(seg, ""); err != nil {
+				return fmt.Errorf("secret pattern %q: %w", p, err)
+			}
+		}
+		add = append(add, secretPattern{segs: segs, anywhere: len(segs) == 1})
+	}
+	for _, p := range add {
+		cm.addSecret(p)
+	}
+	return nil
+}
+
+// secretPattern matches secret key paths.
+type secretPattern struct {
+	segs     []string
+	anywhere bool // a single segment matching at any depth
+}
+
+func (p secretPattern) id() string {
+	return fmt.Sprintf("%t%s%s", p.anywhere, pathSep, pathKey(p.segs))
+}
+
+// match reports whether path, or one of its ancestors, matches p.
+func (p secretPattern) match(path []string) bool {
+	if p.anywhere {
+		for _, seg := range path {
+			if ok, _ := pathpkg.Match(p.segs[0], seg); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) < len(p.segs) {
+		return false
+	}
+	for i, s := range p.segs {
+		if ok, _ := pathpkg.Match(s, path[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// addSecret adds p to the secret patterns. The caller must hold cm.mu.
+func (cm *ConfigManager) addSecret(p secretPattern) {
+	if cm.secrets == nil {
+		cm.secrets = make(map[string]secretPattern)
+	}
+	cm.secrets[p.id()] = p
+}
+
+// isSecret reports whether the value at path is secret. The caller must hold cm.mu.
+func (cm *ConfigManager) isSecret(path []string) bool {
+	for n := len(path); n > 0; n-- {
+		if cm.derived[pathKey(path[:n])] {
+			return true
+		}
+	}
+	for n := len(path); n > 0; n-- {
+		// list elements are recorded with their list
+		if p, ok := cm.provenanceOf(path[:n]); ok {
+			if p.Origin.secret {
+				return true
+			}
+			break
+		}
+	}
+	for _, p := range cm.secrets {
+		if p.match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// mask returns v, the value at path, with every secret value below it
+// wrapped in a Secret. Maps and lists are copied. The caller must hold cm.mu.
+func (cm *ConfigManager) mask(v interface{}, path []string) interface{} {
+	if v == nil {
+		return nil
+	}
+	if len(path) > 0 && cm.isSecret(path) {
+		return Secret{value: copyValue(v)}
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			out[k] = cm.mask(e, appendPath(path, k))
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			out[i] = cm.mask(e, appendPath(path, strconv.Itoa(i)))
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// maskOrigins wraps the values of p in Secret where they are secret.
+// The caller must hold cm.mu.
+func (cm *ConfigManager) maskOrigins(p Provenance, path []string) Provenance {
+	all := cm.isSecret(path)
+	maskOrigin := func(o Origin) Origin {
+		if (all || o.secret) && o.Value != nil {
+			o.Value = Secret{value: o.Value}
+		}
+		return o
+	}
+	p.Origin = maskOrigin(p.Origin)
+	for i, o := range p.Overridden {
+		p.Overridden[i] = maskOrigin(o)
+	}
+	return p
+}
+
+// registerSecrets marks the keys of the secret fields of struct type t.
+func (cm *ConfigManager) registerSecrets(t reflect.Type) {
+	var found []secretPattern
+	secretFields(t, nil, map[reflect.Type]bool{}, &found)
+	if len(found) == 0 {
+		return
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for _, p := range found {
+		cm.addSecret(p)
+	}
+}
+
+// secretFields collects the key paths of the fields of t tagged
+// `secret:"true"` or of type Secret. Elements of lists and maps are "*".
+func secretFields(t reflect.Type, key []string, seen map[reflect.Type]bool, out *[]secretPattern) {
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for _, fp := range planFor(t) {
+		sf := t.Field(fp.index)
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if fp.squash {
+			secretFields(ft, key, seen, out)
+			continue
+		}
+		k := appendPath(key, fp.key)
+		if sf.Tag.Get("secret") == "true" || ft == secretType {
+			*out = append(*out, secretPattern{segs: k})
+			continue
+		}
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array || ft.Kind() == reflect.Map {
+			if ft.Kind() != reflect.Ptr {
+				k = appendPath(k, "*")
+			}
+			ft = ft.Elem()
+		}
+		if ft == secretType {
+			*out = append(*out, secretPattern{segs: k})
+		} else if decodesAsStruct(ft) {
+			secretFields(ft, k, seen, out)
+		}
+	}
+}
+
+// encryptedPaths returns the pathKeys of the encrypted values (see
+// EncryptFields) of a decoded document, with normalized keys.
+func encryptedPaths(v interface{}, path []string, out map[string]bool) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if k != FieldMetadataKey || len(path) > 0 {
+				encryptedPaths(e, appendPath(path, normalizeKey(k)), out)
+			}
+		}
+	case []interface{}:
+		for i, e := range t {
+			encryptedPaths(e, appendPath(path, strconv.Itoa(i)), out)
+		}
+	default:
+		if isEncryptedValue(v) {
+			out[pathKey(path)] = true
+		}
+	}
+}
+
+// hasSecretBelow reports whether secrets has k or a key below it.
+func hasSecretBelow(secrets map[string]bool, k string) bool {
+	if secrets[k] {
+		return true
+	}
+	for s := range secrets {
+		if strings.HasPrefix(s, k+pathSep) {
+			return true
+		}
+	}
+	return false
+}
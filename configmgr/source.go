@@ -0,0 +1,306 @@
+package configmgr
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// Source is a configuration source that can be (re)loaded into a ConfigManager.
+//
+// Load returns the configuration as a tree: nested maps describe nested keys.
+// Keys and values are normalized like those read from files. Custom sources,
+// e.g. an internal metadata service, only need to implement this interface.
+type Source interface {
+	Name() string
+	Load(ctx context.Context) (map[string]interface{}, error)
+}
+
+// WatchableSource is implemented by sources backed by files.
+// Watch polls the returned paths and reloads when one of them changes.
+type WatchableSource interface {
+	Source
+	Paths() []string
+}
+
+// ErrorPolicy decides what Load does when a source fails.
+type ErrorPolicy int
+
+const (
+	// FailOnError aborts Load and returns the error. Nothing from the call is applied.
+	FailOnError ErrorPolicy = iota
+	// SkipOnError logs the error and continues without the source.
+	// On Reload, a failing source keeps its last successfully loaded values.
+	SkipOnError
+)
+
+// SourceOption configures how Load treats a source.
+type SourceOption func(*sourceConfig)
+
+type sourceConfig struct {
+	priority    int
+	hasPriority bool // priority was given with WithPriority
+	optional    bool
+	policy      ErrorPolicy
+}
+
+// WithPriority sets the merge priority of a source. Sources with a higher
+// priority override those with a lower one regardless of load order; sources
+// with equal priority (the default is 0) override in load order.
+func WithPriority(p int) SourceOption {
+	return func(c *sourceConfig) { c.priority, c.hasPriority = p, true }
+}
+
+// Optional marks a source whose file may be missing. A missing file loads as
+// empty, but stays watched so creating it later triggers a reload.
+// Sources are required by default.
+func Optional() SourceOption {
+	return func(c *sourceConfig) { c.optional = true }
+}
+
+// Required marks a source whose file must exist. This is the default.
+func Required() SourceOption {
+	return func(c *sourceConfig) { c.optional = false }
+}
+
+// WithErrorPolicy sets what happens when the source fails to load.
+func WithErrorPolicy(p ErrorPolicy) SourceOption {
+	return func(c *sourceConfig) { c.policy = p }
+}
+
+// Configure attaches load options to a source.
+//
+//	cm.Load(ctx,
+//		configmgr.FileSource("config.yaml"),
+//		configmgr.Configure(configmgr.FileSource("config.local.yaml"), configmgr.Optional()),
+//		configmgr.Configure(metadataSource, configmgr.WithPriority(10), configmgr.WithErrorPolicy(configmgr.SkipOnError)),
+//	)
+func Configure(src Source, opts ...SourceOption) Source {
+	return &configuredSource{Source: src, opts: opts}
+}
+
+type configuredSource struct {
+	Source
+	opts []SourceOption
+}
+
+// Load loads sources in order and adds them to the source stack.
+//
+// All sources are loaded before anything is applied: with the default
+// FailOnError policy a failing source leaves the configuration untouched.
+// Loaded sources take part in Reload and, when they are WatchableSource, in Watch.
+// With EnableInterpolation, an unresolvable reference also fails Load.
+func (cm *ConfigManager) Load(ctx context.Context, sources ...Source) error {
+	cm.mu.RLock()
+	opts := cm.loadOptions()
+	cm.mu.RUnlock()
+
+	var layers []*layer
+	for _, src := range sources {
+		ls, err := flattenSource(src, sourceConfig{})
+		if err != nil {
+			return err
+		}
+		layers = append(layers, ls...)
+	}
+
+	if err := cm.fetchLayers(ctx, layers, opts, "load_source_skipped"); err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	prev := append([]*layer(nil), cm.layers...)
+	var err error
+	for _, l := range layers {
+		err = cm.insertLayer(l)
+	}
+	if err != nil {
+		// keep the configuration as it was
+		cm.layers = prev
+		_ = cm.rebuild()
+		return err
+	}
+	return nil
+}
+
+// fetchLayers loads new layers. A layer failing with SkipOnError is logged
+// as event and loads as empty.
+func (cm *ConfigManager) fetchLayers(ctx context.Context, layers []*layer, opts loadOptions, event string) error {
+	for _, l := range layers {
+		if l.src == nil {
+			continue // the empty layer of a composite source
+		}
+		res, err := l.fetch(ctx, opts)
+		if err != nil {
+			if l.policy != SkipOnError {
+				return err
+			}
+			cm.logError(event, err, map[string]interface{}{"source": l.src.Name()})
+			res = loaded{tree: map[string]interface{}{}}
+		}
+		l.tree, l.lines, l.secrets = res.tree, res.lines, res.secrets
+	}
+	return nil
+}
+
+// flattenSource unwraps options and expands composite sources into layers.
+func flattenSource(src Source, cfg sourceConfig) ([]*layer, error) {
+	if c, ok := src.(*configuredSource); ok {
+		for _, opt := range c.opts {
+			opt(&cfg)
+		}
+		return flattenSource(c.Source, cfg)
+	}
+	if e, ok := src.(expandableSource); ok {
+		children, err := e.expand()
+		if err != nil && !(cfg.optional && errors.Is(err, fs.ErrNotExist)) {
+			return nil, err
+		}
+		// a missing optional source, e.g. a DirSource directory, expands to nothing
+		var layers []*layer
+		for _, child := range children {
+			ls, err := flattenSource(child, cfg)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, ls...)
+		}
+		if len(layers) == 0 {
+			// an empty layer keeps the source in the stack, so Reload expands it again
+			layers = []*layer{{kind: src.Name(), priority: cfg.priority, tree: map[string]interface{}{}}}
+		}
+		g := &layerGroup{src: src, cfg: cfg}
+		if w, ok := src.(WatchableSource); ok {
+			g.paths = w.Paths()
+		}
+		for _, l := range layers {
+			l.group = g
+		}
+		return layers, nil
+	}
+
+	if p, ok := src.(prioritizedSource); ok && !cfg.hasPriority {
+		cfg.priority = p.defaultPriority()
+	}
+	l := &layer{src: src, kind: src.Name(), priority: cfg.priority, optional: cfg.optional, policy: cfg.policy}
+	if d, ok := src.(describedSource); ok {
+		l.kind, l.path = d.origin()
+	}
+	if w, ok := src.(WatchableSource); ok {
+		l.paths = w.Paths()
+	}
+	return []*layer{l}, nil
+}
+
+// locatedSource is implemented by built-in sources: they honour the manager's
+// settings (key delimiter, keys) and report the line of every key.
+type locatedSource interface {
+	load(ctx context.Context, opts loadOptions) (loaded, error)
+}
+
+// loadOptions carries the manager settings built-in sources depend on.
+type loadOptions struct {
+	delimiter string
+	keys      KeyProvider // decrypts field-level encrypted values, may be nil
+	envFiles  bool        // see EnableEnvFiles
+}
+
+// loadOptions returns the current load settings. The caller must hold cm.mu.
+func (cm *ConfigManager) loadOptions() loadOptions {
+	return loadOptions{delimiter: cm.delimiter, keys: cm.keys, envFiles: cm.envFiles}
+}
+
+// describedSource is implemented by built-in sources to report their kind and path to Explain.
+type describedSource interface {
+	origin() (kind, path string)
+}
+
+// prioritizedSource is implemented by built-in sources whose priority is not
+// 0 unless set with WithPriority, e.g. command-line flags.
+type prioritizedSource interface {
+	defaultPriority() int
+}
+
+// expandableSource is implemented by sources that stand for several layers,
+// e.g. a base file plus its profile file. Each layer keeps its own provenance.
+type expandableSource interface {
+	expand() ([]Source, error)
+}
+
+// fetch loads the layer's source.
+func (l *layer) fetch(ctx context.Context, opts loadOptions) (loaded, error) {
+	var res loaded
+	var err error
+	if ls, ok := l.src.(locatedSource); ok {
+		res, err = ls.load(ctx, opts)
+	} else {
+		var tree map[string]interface{}
+		if tree, err = l.src.Load(ctx); err == nil {
+			res = loaded{tree: normalizeTree(tree)}
+		}
+	}
+	if err != nil && l.optional && errors.Is(err, fs.ErrNotExist) {
+		return loaded{tree: map[string]interface{}{}}, nil
+	}
+	return res, err
+}
+
+// insertLayer adds l to the stack after every layer with the same or a lower
+// priority, and updates the config data. The error is an interpolation
+// failure; the layer is inserted anyway. The caller must hold cm.mu.
+func (cm *ConfigManager) insertLayer(l *layer) error {
+	i := sort.Search(len(cm.layers), func(i int) bool { return cm.layers[i].priority > l.priority })
+	if i == len(cm.layers) {
+		cm.layers = append(cm.layers, l)
+		cm.mergeLayer(l)
+		return cm.resolve()
+	}
+	cm.layers = append(cm.layers, nil)
+	copy(cm.layers[i+1:], cm.layers[i:])
+	cm.layers[i] = l
+	return cm.rebuild()
+}
+
+// rebuild merges all layers from scratch and resolves the result. The
+// caller must hold cm.mu.
+func (cm *ConfigManager) rebuild() error {
+	cm.raw = make(map[string]interface{})
+	cm.provenance = make(map[string]*Provenance)
+	for _, l := range cm.layers {
+		cm.mergeLayer(l)
+	}
+	return cm.resolve()
+}
+
+// loadTree is the Source.Load implementation shared by built-in sources.
+func loadTree(ctx context.Context, s locatedSource) (map[string]interface{}, error) {
+	res, err := s.load(ctx, loadOptions{delimiter: DefaultKeyDelimiter})
+	return res.tree, err
+}
+
+// mergeSources is the Source.Load implementation of composite sources.
+func mergeSources(ctx context.Context, e expandableSource) (map[string]interface{}, error) {
+	children, err := e.expand()
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]*layer, 0, len(children))
+	for _, child := range children {
+		ls, err := flattenSource(child, sourceConfig{})
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, ls...)
+	}
+	tree := make(map[string]interface{})
+	for _, l := range layers {
+		res, err := l.fetch(ctx, loadOptions{delimiter: DefaultKeyDelimiter})
+		if err != nil {
+			return nil, err
+		}
+		mergeMaps(tree, res.tree)
+	}
+	return tree, nil
+}
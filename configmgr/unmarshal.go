@@ -1,68 +1,209 @@
 package configmgr
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
-	"strconv"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
 )
 
+// validate is shared by all managers; it caches struct metadata and is safe for concurrent use.
+var validate = validator.New()
+
 // Unmarshal fills the given struct with config values, applies defaults and validates.
-func (cm *ConfigManager) Unmarshal(target interface{}) error {
-	// convert map -> JSON -> struct
-	raw, err := json.Marshal(cm.data)
-	if err != nil {
-		return err
+//
+// Fields are matched to keys by their `config` tag, then their `json` tag, then
+// their name, ignoring case. Values are converted leniently (e.g. "8080" into an
+// int, "5s" into a time.Duration), and types implementing encoding.TextUnmarshaler
+// (net.IP, ...) as well as url.URL are decoded from strings. With Strict, keys
+// no field consumes are an error. The keys of fields tagged `secret:"true"` or
+// of type Secret become secret (see Secret).
+//
+// All problems are reported at once in a *ConfigError: fields that cannot be
+// decoded are skipped, and the others are still decoded, defaulted and validated.
+func (cm *ConfigManager) Unmarshal(target interface{}, opts ...UnmarshalOption) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unmarshal target must be a non-nil pointer to a struct, got %T", target)
 	}
-	if err = json.Unmarshal(raw, target); err != nil {
-		return err
+
+	d := &decoder{}
+	for _, opt := range opts {
+		opt(d)
 	}
 
+	cm.mu.RLock()
+	data := cm.view()
+	var unused []Provenance
+	if d.strict {
+		used := make(map[string]bool)
+		coverKeys(rv.Elem().Type(), data, nil, used)
+		unused = cm.unused(data, used)
+	}
+	cm.mu.RUnlock()
+	cm.registerSecrets(rv.Elem().Type())
+	d.decodeStruct(rv.Elem(), data, "")
+	errs := d.errs
+
 	// apply defaults
-	applyDefaults(target)
+	if err := applyDefaults(target); err != nil {
+		errs = append(errs, err)
+	}
 
 	// validate
-	validate := validator.New()
-	if err = validate.Struct(target); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+	var verrs validator.ValidationErrors
+	if err := validate.Struct(target); err != nil {
+		if errors.As(err, &verrs) {
+			for _, fe := range verrs {
+				errs = append(errs, fe)
+			}
+		} else {
+			errs = append(errs, err)
+		}
 	}
 
+	if len(unused) > 0 {
+		errs = append(errs, &UnusedKeysError{Keys: unused})
+	}
+	if len(errs) > 0 {
+		ce := cm.configError(rv.Elem().Type(), errs)
+		ce.validation = verrs
+		return ce
+	}
 	return nil
 }
 
 // applyDefaults checks struct tags `default:"value"` and applies if empty.
-func applyDefaults(target interface{}) {
-	v := reflect.ValueOf(target).Elem()
+//
+// It recurses into nested and embedded structs, pointers to structs (allocated
+// when something below them has a default), and the struct elements of slices
+// and maps. Default values are parsed as YAML, so besides scalars they may be
+// lists (`default:"[a,b]"` or `default:"a,b"`), maps (`default:"{k: v}"`) and
+// durations (`default:"5s"`).
+func applyDefaults(target interface{}) error {
+	return applyStructDefaults(reflect.ValueOf(target).Elem(), "")
+}
+
+func applyStructDefaults(v reflect.Value, path string) error {
 	t := v.Type()
 
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
 		fieldType := t.Field(i)
+		if !field.CanSet() {
+			continue // unexported
+		}
+		fieldPath := fieldType.Name
+		if path != "" {
+			fieldPath = path + "." + fieldType.Name
+		}
+
+		if defaultVal, ok := fieldType.Tag.Lookup("default"); ok && isZero(field) {
+			if err := setDefault(field, defaultVal); err != nil {
+				return &pathError{path: fieldPath, value: defaultVal, rule: "default", err: fmt.Errorf("invalid default: %w", err)}
+			}
+		}
 
-		defaultVal := fieldType.Tag.Get("default")
-		if defaultVal == "" {
-			continue
+		if err := applyValueDefaults(field, fieldPath); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// applyValueDefaults descends into v looking for nested structs with defaults.
+func applyValueDefaults(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		return applyStructDefaults(v, path)
 
-		if isZero(field) {
-			switch field.Kind() {
-			case reflect.String:
-				field.SetString(defaultVal)
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				if i64, err := strconv.ParseInt(defaultVal, 10, 64); err == nil {
-					field.SetInt(i64)
-				}
-			case reflect.Bool:
-				if b, err := strconv.ParseBool(defaultVal); err == nil {
-					field.SetBool(b)
-				}
-			default:
-				return
+	case reflect.Ptr:
+		if v.IsNil() {
+			if !hasDefaults(v.Type().Elem(), map[reflect.Type]bool{}) {
+				return nil
 			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return applyValueDefaults(v.Elem(), path)
+
+	case reflect.Slice, reflect.Array:
+		if !hasDefaults(v.Type().Elem(), map[reflect.Type]bool{}) {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := applyValueDefaults(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if v.IsNil() || !hasDefaults(v.Type().Elem(), map[reflect.Type]bool{}) {
+			return nil
+		}
+		// map elements are not addressable: update a copy and store it back
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := applyValueDefaults(elem, fmt.Sprintf("%s[%v]", path, iter.Key())); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return nil
+}
+
+// hasDefaults reports whether t, or any struct reachable from it, has a default tag.
+func hasDefaults(t reflect.Type, seen map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return false
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if _, ok := f.Tag.Lookup("default"); ok || hasDefaults(f.Type, seen) {
+			return true
 		}
 	}
+	return false
+}
+
+// setDefault parses a default tag value into field.
+func setDefault(field reflect.Value, defaultVal string) error {
+	if field.Type() == secretType {
+		field.Set(reflect.ValueOf(Secret{value: normalizeValue(defaultVal)}))
+		return nil
+	}
+	if field.Kind() == reflect.String {
+		field.SetString(defaultVal)
+		return nil
+	}
+
+	text := strings.TrimSpace(defaultVal)
+	switch field.Kind() {
+	case reflect.Slice, reflect.Array:
+		if !strings.HasPrefix(text, "[") {
+			text = "[" + text + "]"
+		}
+	case reflect.Map:
+		if !strings.HasPrefix(text, "{") {
+			text = "{" + text + "}"
+		}
+	}
+
+	ptr := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(text), ptr.Interface()); err != nil {
+		return err
+	}
+	field.Set(ptr.Elem())
+	return nil
 }
 
 // isZero checks if a value is zero
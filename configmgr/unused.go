@@ -0,0 +1,136 @@
+package configmgr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Strict makes Unmarshal fail with an *UnusedKeysError when the config has
+// keys that no field of the target consumes, e.g. a misspelled APP_PROT.
+func Strict() UnmarshalOption {
+	return func(d *decoder) { d.strict = true }
+}
+
+// UnusedKeysError lists the config keys no struct field consumed, with the
+// source that set each of them.
+type UnusedKeysError struct {
+	Keys []Provenance
+}
+
+func (e *UnusedKeysError) Error() string {
+	keys := make([]string, len(e.Keys))
+	for i, p := range e.Keys {
+		keys[i] = fmt.Sprintf("%s (from %s)", p.Key, p.Origin)
+	}
+	return fmt.Sprintf("%d unused config key(s): %s", len(keys), strings.Join(keys, ", "))
+}
+
+// UnusedKeys reports the leaf keys of the config that no field of the given
+// structs (or pointers to structs) would consume in Unmarshal, sorted by key,
+// with their provenance. Keys decoded into maps, slices or interface fields
+// count as consumed together with everything below them.
+//
+// A *Schema target consumes the keys declared in its properties.
+func (cm *ConfigManager) UnusedKeys(targets ...interface{}) []Provenance {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	used := make(map[string]bool)
+	data := cm.view()
+	for _, target := range targets {
+		if s, ok := target.(*Schema); ok {
+			coverSchemaKeys(s, data, nil, used)
+			continue
+		}
+		t := reflect.TypeOf(target)
+		for t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t != nil && t.Kind() == reflect.Struct {
+			coverKeys(t, data, nil, used)
+		}
+	}
+	return cm.unused(data, used)
+}
+
+// unused returns the provenance of the leaves of data not covered by used.
+// The caller must hold cm.mu.
+func (cm *ConfigManager) unused(data map[string]interface{}, used map[string]bool) []Provenance {
+	var out []Provenance
+	for _, leaf := range leafPaths(data, nil) {
+		if covered(leaf, used) {
+			continue
+		}
+		p, _ := cm.explain(leaf)
+		out = append(out, p)
+	}
+	return out
+}
+
+// covered reports whether path or one of its ancestors is in used.
+func covered(path []string, used map[string]bool) bool {
+	for i := 1; i <= len(path); i++ {
+		if used[pathKey(path[:i])] {
+			return true
+		}
+	}
+	return false
+}
+
+// coverKeys marks the keys of tree that decoding into struct type t consumes,
+// following the same field plans as decodeStruct.
+func coverKeys(t reflect.Type, tree map[string]interface{}, path []string, used map[string]bool) {
+	for _, fp := range planFor(t) {
+		ft := t.Field(fp.index).Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if fp.squash {
+			coverKeys(ft, tree, path, used)
+			continue
+		}
+		raw, ok := tree[fp.key]
+		if !ok {
+			continue
+		}
+		p := appendPath(path, fp.key)
+		if m, isMap := raw.(map[string]interface{}); isMap && decodesAsStruct(ft) {
+			coverKeys(ft, m, p, used)
+			continue
+		}
+		used[pathKey(p)] = true
+	}
+}
+
+// decodesAsStruct reports whether decode fills t field by field from a map.
+func decodesAsStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && t != urlType && t != secretType
+}
+
+// coverSchemaKeys marks the keys of tree declared by schema s. An object
+// schema without properties declares everything below it.
+func coverSchemaKeys(s *Schema, tree map[string]interface{}, path []string, used map[string]bool) {
+	if s == nil || len(s.Properties) == 0 {
+		if len(path) > 0 {
+			used[pathKey(path)] = true
+		} else {
+			for k := range tree {
+				used[pathKey([]string{k})] = true
+			}
+		}
+		return
+	}
+	for name, prop := range s.Properties {
+		key := normalizeKey(name)
+		raw, ok := tree[key]
+		if !ok {
+			continue
+		}
+		p := appendPath(path, key)
+		if m, isMap := raw.(map[string]interface{}); isMap && prop != nil && len(prop.Properties) > 0 {
+			coverSchemaKeys(prop, m, p, used)
+			continue
+		}
+		used[pathKey(p)] = true
+	}
+}
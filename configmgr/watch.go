@@ -0,0 +1,141 @@
+package configmgr
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultPollInterval is how often Watch checks the loaded files for changes.
+	DefaultPollInterval = time.Second
+	// DefaultDebounce is how long files must stay unchanged before Watch reloads.
+	DefaultDebounce = 500 * time.Millisecond
+)
+
+// WatchOption configures Watch.
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	interval time.Duration
+	debounce time.Duration
+}
+
+// WithPollInterval sets how often watched files are checked for changes.
+func WithPollInterval(d time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		if d > 0 {
+			o.interval = d
+		}
+	}
+}
+
+// WithDebounce sets how long watched files must stay unchanged before a reload.
+// Editors and deploy tools often write a file in several steps; debouncing
+// turns such bursts into a single reload.
+func WithDebounce(d time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		if d >= 0 {
+			o.debounce = d
+		}
+	}
+}
+
+// ErrAlreadyWatching is returned by Watch when the manager is already being watched.
+var ErrAlreadyWatching = errors.New("configmgr: already watching")
+
+// Watch starts polling every file loaded through LoadFromFile, LoadFiles,
+// LoadFromDotEnv, LoadWithProfile and LoadEncryptedFile, as well as the paths
+// of any WatchableSource passed to Load. When a file changes
+// (and stays unchanged for the debounce period) the whole source stack is
+// rebuilt via Reload and OnChange subscribers are notified.
+//
+// Watch returns immediately; watching stops when ctx is done.
+// Reload errors are reported to the logger and the previous configuration is kept.
+func (cm *ConfigManager) Watch(ctx context.Context, opts ...WatchOption) error {
+	o := watchOptions{interval: DefaultPollInterval, debounce: DefaultDebounce}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cm.mu.Lock()
+	if cm.watching {
+		cm.mu.Unlock()
+		return ErrAlreadyWatching
+	}
+	cm.watching = true
+	cm.mu.Unlock()
+
+	state := cm.fileStates()
+	go func() {
+		defer func() {
+			cm.mu.Lock()
+			cm.watching = false
+			cm.mu.Unlock()
+		}()
+
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+
+		var pending bool
+		var lastChange time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current := cm.fileStates()
+			if statesChanged(state, current) {
+				pending = true
+				lastChange = time.Now()
+			}
+			state = current
+			if !pending || time.Since(lastChange) < o.debounce {
+				continue
+			}
+			pending = false
+			if err := cm.reload(ctx); err != nil {
+				cm.logError("config_reload_failed", err, nil)
+			}
+		}
+	}()
+	return nil
+}
+
+// fileState is what Watch compares between polls.
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func (cm *ConfigManager) fileStates() map[string]fileState {
+	states := make(map[string]fileState)
+	for _, p := range cm.watchedPaths() {
+		info, err := os.Stat(p)
+		if err != nil {
+			states[p] = fileState{}
+			continue
+		}
+		states[p] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+	}
+	return states
+}
+
+// statesChanged reports whether any previously seen file changed.
+// Paths that only appear in cur belong to newly loaded sources and are not a change.
+func statesChanged(prev, cur map[string]fileState) bool {
+	for p, s := range prev {
+		c, ok := cur[p]
+		if !ok {
+			continue
+		}
+		if c.exists != s.exists || c.size != s.size || !c.modTime.Equal(s.modTime) {
+			return true
+		}
+	}
+	return false
+}